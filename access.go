@@ -0,0 +1,89 @@
+package btree
+
+import "sort"
+
+// HotRange is a contiguous range of items in a Tree along with an
+// estimate of how many times items in that range have been accessed.
+type HotRange[T any] struct {
+	Start, End T
+	Hits       uint64
+}
+
+type accessTracker[T any] struct {
+	hits        map[*node[T]]uint64
+	every, tick uint64
+}
+
+// TrackAccess makes the Tree count how often items are found by Get and Fetch.
+// Only one of every sampleEvery lookups is counted to keep the overhead down.
+// Every lookup still advances the sampling counter, so while tracking is on
+// Get and Fetch write to the Tree, and concurrent readers must take a write
+// lock around them instead of sharing a read lock.
+// Calling TrackAccess with sampleEvery <= 0 stops tracking and discards the counts.
+func (t *Tree[T]) TrackAccess(sampleEvery int) {
+	t.access = nil
+	if sampleEvery > 0 {
+		t.grow()
+		t.access = &accessTracker[T]{
+			hits:  map[*node[T]]uint64{},
+			every: uint64(sampleEvery),
+		}
+	}
+}
+
+func (a *accessTracker[T]) accessed(n *node[T]) {
+	if a.tick++; a.tick%a.every == 0 {
+		a.hits[n]++
+	}
+}
+
+// swap is called when remove moves items between nodes.
+func (a *accessTracker[T]) swap(x, y *node[T]) {
+	hx, okx := a.hits[x]
+	hy, oky := a.hits[y]
+	delete(a.hits, x)
+	delete(a.hits, y)
+	if okx {
+		a.hits[y] = hx
+	}
+	if oky {
+		a.hits[x] = hy
+	}
+}
+
+// HotRanges returns up to n ranges of items that have been accessed the most
+// since TrackAccess was called, ordered from most to least accessed.
+// The Tree is divided into 4*n ranges holding equal numbers of items for this
+// purpose, and Hits is scaled up to account for sampling.
+// HotRanges walks the entire Tree, and returns nil if access is not being tracked.
+func (t *Tree[T]) HotRanges(n int) []HotRange[T] {
+	if t.access == nil || n <= 0 || t.count == 0 {
+		return nil
+	}
+	width := t.count / (4 * n)
+	if width == 0 {
+		width = 1
+	}
+	var res []HotRange[T]
+	var cur HotRange[T]
+	iter := t.Iterator(nil, nil)
+	for i := 0; iter.Next(); i++ {
+		if i%width == 0 {
+			if i > 0 {
+				res = append(res, cur)
+			}
+			cur = HotRange[T]{Start: iter.Item()}
+		}
+		cur.End = iter.Item()
+		cur.Hits += t.access.hits[iter.workingNode] * t.access.every
+	}
+	res = append(res, cur)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Hits > res[j].Hits })
+	if len(res) > n {
+		res = res[:n]
+	}
+	for len(res) > 0 && res[len(res)-1].Hits == 0 {
+		res = res[:len(res)-1]
+	}
+	return res
+}
@@ -0,0 +1,194 @@
+package btree
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// AnomalyKind identifies the sort of suspicious condition an Anomaly describes.
+type AnomalyKind string
+
+const (
+	// AnomalyComparator means the Tree's LessThan gave inconsistent answers.
+	AnomalyComparator AnomalyKind = "comparator"
+	// AnomalyModifiedDuringIteration means an Iterator was advanced after the
+	// Tree it was iterating over was modified.
+	AnomalyModifiedDuringIteration AnomalyKind = "modified during iteration"
+	// AnomalyTooDeep means the Tree is taller than an AVL tree with Len items can be.
+	AnomalyTooDeep AnomalyKind = "too deep"
+	// AnomalyHeightRatio means the Tree's HeightRatio went over Alarms.MaxHeightRatio.
+	AnomalyHeightRatio AnomalyKind = "height ratio"
+	// AnomalyRebalanceRate means the Tree rebalanced more often than Alarms.MaxRebalanceRate allows.
+	AnomalyRebalanceRate AnomalyKind = "rebalance rate"
+)
+
+// Anomaly describes a suspicious condition detected by a Tree.
+type Anomaly struct {
+	Kind    AnomalyKind
+	Message string
+	// Len, Height, and Version describe the Tree when the Anomaly was detected.
+	Len     int
+	Height  int
+	Version uint64
+}
+
+// Logger is notified of anomalies a Tree detects.  Anomalies usually indicate a bug in
+// the program using the Tree, and are likely to be followed by wrong results or corrupted data.
+type Logger interface {
+	LogAnomaly(Anomaly)
+}
+
+// SetLogger makes the Tree check for anomalies while it is being used and
+// report them to l.  The checks cost a little extra time on each Insert and
+// Iterator step.  Passing nil turns the checks off.
+func (t *Tree[T]) SetLogger(l Logger) {
+	t.logger = l
+}
+
+func (t *Tree[T]) newAnomaly(kind AnomalyKind, msg string) Anomaly {
+	a := Anomaly{Kind: kind, Message: msg, Len: t.count, Version: t.version}
+	if t.root != nil {
+		a.Height = int(t.root.h)
+	}
+	return a
+}
+
+func (t *Tree[T]) anomaly(kind AnomalyKind, msg string) {
+	t.logger.LogAnomaly(t.newAnomaly(kind, msg))
+}
+
+// maxAVLHeight is the tallest an AVL tree holding count items can get.
+func maxAVLHeight(count int) int {
+	return int(1.4405*math.Log2(float64(count)+2) - 0.3277)
+}
+
+// checkInsert looks for anomalies after item has been inserted.
+func (t *Tree[T]) checkInsert(item T) {
+	if t.less(item, item) {
+		t.anomaly(AnomalyComparator, "item is less than itself")
+	}
+	if !t.deferred && t.root != nil && int(t.root.h) > maxAVLHeight(t.count) {
+		t.anomaly(AnomalyTooDeep, "tree is taller than AVL balance allows")
+	}
+}
+
+// modified is called when an Iterator notices that the Tree was modified
+// since the Iterator was created.
+func (i *Iterator[T]) modified() {
+	i.err = ErrModifiedDuringIteration
+	if i.t.logger != nil {
+		i.t.anomaly(AnomalyModifiedDuringIteration, "tree changed while an Iterator was using it")
+	}
+	i.version = i.t.version
+}
+
+// Alarms are thresholds on derived Tree statistics that are checked as the
+// Tree is modified, so that a broken comparator or a pathological workload
+// is reported instead of silently slowing lookups down.
+type Alarms struct {
+	// MaxHeightRatio is the largest HeightRatio allowed.  AVL trees stay
+	// under about 1.44 unless rebalancing is deferred.  0 disables the check.
+	MaxHeightRatio float64
+	// MaxRebalanceRate is the most rebalances per second allowed.
+	// 0 disables the check.
+	MaxRebalanceRate float64
+	// OnAlarm is called when a threshold is exceeded.  If it is nil,
+	// the Tree's Logger is told instead.
+	OnAlarm func(Anomaly)
+}
+
+// alarmCheckEvery is how many inserts and deletes happen between alarm checks.
+const alarmCheckEvery = 256
+
+type alarmState struct {
+	Alarms
+	ops, rebalances uint64
+	last            time.Time
+	rate            float64
+}
+
+// SetAlarms makes the Tree check the thresholds in a after every few hundred inserts and deletes.
+// The rebalance rate is measured over the time since the previous check.
+// Passing nil turns the checks off.
+func (t *Tree[T]) SetAlarms(a *Alarms) {
+	t.alarms = nil
+	if a != nil {
+		t.alarms = &alarmState{
+			Alarms:     *a,
+			last:       t.now(),
+			rebalances: t.insertRebalanceCount + t.removeRebalanceCount,
+		}
+	}
+}
+
+func (t *Tree[T]) checkAlarms() {
+	a := t.alarms
+	if a.ops++; a.ops%alarmCheckEvery != 0 {
+		return
+	}
+	now := t.now()
+	rebalances := t.insertRebalanceCount + t.removeRebalanceCount
+	if elapsed := now.Sub(a.last).Seconds(); elapsed > 0 {
+		a.rate = float64(rebalances-a.rebalances) / elapsed
+	}
+	a.last, a.rebalances = now, rebalances
+	if ratio := t.HeightRatio(); a.MaxHeightRatio > 0 && ratio > a.MaxHeightRatio {
+		t.alarm(AnomalyHeightRatio, fmt.Sprintf("height ratio %.2f is over %.2f", ratio, a.MaxHeightRatio))
+	}
+	if a.MaxRebalanceRate > 0 && a.rate > a.MaxRebalanceRate {
+		t.alarm(AnomalyRebalanceRate, fmt.Sprintf("%.0f rebalances per second is over %.0f", a.rate, a.MaxRebalanceRate))
+	}
+}
+
+func (t *Tree[T]) alarm(kind AnomalyKind, msg string) {
+	switch {
+	case t.alarms.OnAlarm != nil:
+		t.alarms.OnAlarm(t.newAnomaly(kind, msg))
+	case t.logger != nil:
+		t.anomaly(kind, msg)
+	}
+}
+
+type depthGuard struct {
+	slack  int
+	panics bool
+}
+
+// SetDepthGuard makes Get, Insert, Delete, and the other operations that search
+// the Tree check that they never go more than 2*log2(Len)+slack levels deep,
+// or 4*log2(Len)+slack while rebalancing is deferred.  A healthy Tree never
+// gets that deep, so going past the limit means the comparator is inconsistent
+// or the Tree is corrupt.  If panics is true, the Tree panics when that happens.
+// Otherwise, an AnomalyTooDeep is reported to the Tree's Logger.
+// The check costs a counter and a comparison per level.
+// Passing a negative slack turns the check off.
+func (t *Tree[T]) SetDepthGuard(slack int, panics bool) {
+	t.guard = nil
+	if slack >= 0 {
+		t.guard = &depthGuard{slack: slack, panics: panics}
+	}
+}
+
+// depthLimit returns the depth at which a search should call tooDeep,
+// or -1 if depth is not being checked.
+func (t *Tree[T]) depthLimit() int {
+	if t.guard == nil {
+		return -1
+	}
+	if t.deferred {
+		return int(maxDeferredHeight(t.count)) + t.guard.slack
+	}
+	return 2*bits.Len(uint(t.count)) + t.guard.slack
+}
+
+func (t *Tree[T]) tooDeep(limit int) {
+	msg := fmt.Sprintf("search went more than %d levels deep", limit)
+	if t.guard.panics {
+		panic("Tree is corrupt: " + msg)
+	}
+	if t.logger != nil {
+		t.anomaly(AnomalyTooDeep, msg)
+	}
+}
@@ -0,0 +1,172 @@
+package btree
+
+// Loader fetches items from a backing store on behalf of a Tree.
+// Load is called by Get when the Tree does not contain an item matching
+// cmp.  If Load returns true, the returned item is returned from Get, and
+// is added to the Tree if it passes the domain check and Quota that Insert
+// would apply.  Items that do not pass are returned without being added.
+type Loader[T any] interface {
+	Load(cmp CompareAgainst[T]) (item T, found bool)
+}
+
+// Flusher writes changes made to a Tree back to a backing store.
+// Flush is called with each item passed to Insert, and with each item removed
+// by Delete with deleted set to true.  Items added to the Tree by a Loader
+// are not flushed.
+type Flusher[T any] interface {
+	Flush(item T, deleted bool)
+}
+
+type flushReq[T any] struct {
+	item    T
+	deleted bool
+}
+
+// SetLoader makes the Tree use l to fetch items that Get cannot find.
+// Passing nil removes any existing Loader.  Since Get and Has add the items
+// l finds to the Tree, they modify it while a Loader is set, and callers that
+// share the Tree must hold a write lock around them, not a read lock.
+func (t *Tree[T]) SetLoader(l Loader[T]) {
+	t.loader = l
+}
+
+// SetFlusher makes the Tree call f whenever Insert or Delete changes the
+// Tree.  If async is false, f is called before Insert or Delete returns.
+// If async is true, calls to f are queued and made in order from a
+// separate goroutine, and f must not access the Tree.
+// Passing a nil f removes any existing Flusher after all pending
+// asynchronous flushes have finished. Release does the same.  The goroutine
+// runs until one of them is called, so a Tree with an asynchronous Flusher
+// must be released when it is no longer needed, or the goroutine leaks.
+func (t *Tree[T]) SetFlusher(f Flusher[T], async bool) {
+	t.stopFlusher()
+	t.flusher = f
+	if f == nil || !async {
+		return
+	}
+	q, done := make(chan flushReq[T], 64), make(chan struct{})
+	go func() {
+		for req := range q {
+			f.Flush(req.item, req.deleted)
+		}
+		close(done)
+	}()
+	t.flushQ, t.flushDone = q, done
+}
+
+func (t *Tree[T]) stopFlusher() {
+	if t.flushQ != nil {
+		close(t.flushQ)
+		<-t.flushDone
+		t.flushQ, t.flushDone = nil, nil
+	}
+	t.flusher = nil
+}
+
+func (t *Tree[T]) flush(item T, deleted bool) {
+	if deleted && t.dirty != nil {
+		t.dirty.Delete(item)
+	}
+	switch {
+	case t.flushQ != nil:
+		t.flushQ <- flushReq[T]{item: item, deleted: deleted}
+	case t.flusher != nil:
+		t.flusher.Flush(item, deleted)
+	}
+}
+
+// load asks the Loader for the item matching cmp, and adds it to the Tree
+// with the same checks Insert makes.  Loaded items are not flushed.
+func (t *Tree[T]) load(cmp CompareAgainst[T]) (item T, found bool) {
+	if item, found = t.loader.Load(cmp); !found {
+		return
+	}
+	t.mustInit()
+	if t.domain != nil && t.domain(item) != nil {
+		return
+	}
+	t.callGate()
+	if t.quota != nil && !t.quota.admit(t, item) {
+		return
+	}
+	t.insertItem(item)
+	if t.logger != nil {
+		t.checkInsert(item)
+	}
+	if t.alarms != nil {
+		t.checkAlarms()
+	}
+	return
+}
+
+// MarkDirty marks the item in the Tree matching cmp as needing to be written
+// to a backing store by FlushDirty.  It returns false if there is no such item.
+// Dirty items are tracked in a separate index, so FlushDirty only has to visit
+// the items that were marked.  Deleting an item clears its mark, and replacing
+// it with Insert keeps it.  Copy and Clone do not carry marks over.
+func (t *Tree[T]) MarkDirty(cmp CompareAgainst[T]) bool {
+	if t.small != nil {
+		i, found := t.getSmall(cmp)
+		if found {
+			t.markDirty(t.small[i])
+		}
+		return found
+	}
+	h := t.root
+	for h != nil {
+		switch cmp(h.i) {
+		case Greater:
+			h = h.l
+		case Less:
+			h = h.r
+		case Equal:
+			t.markDirty(h.i)
+			return true
+		default:
+			panic(unorderable)
+		}
+	}
+	return false
+}
+
+// markDirty adds item to the index of dirty items.
+func (t *Tree[T]) markDirty(item T) {
+	if t.dirty == nil {
+		t.dirty = t.Copy()
+		t.dirty.intern = nil
+	}
+	t.dirty.insertItem(item)
+}
+
+// DirtyCount returns the number of items marked by MarkDirty that have not
+// been flushed yet.
+func (t *Tree[T]) DirtyCount() int {
+	if t.dirty == nil {
+		return 0
+	}
+	return t.dirty.count
+}
+
+// FlushDirty calls fn in ascending order with the current version of each
+// item marked by MarkDirty, clearing the mark when fn succeeds.  FlushDirty
+// stops and returns the first error fn returns, leaving that item and any
+// that have not been visited yet marked.  fn must not modify the Tree.
+func (t *Tree[T]) FlushDirty(fn func(T) error) error {
+	if t.dirty == nil {
+		return nil
+	}
+	items := make([]T, 0, t.dirty.count)
+	scan(t.dirty.view(), nil, nil, func(v T) bool {
+		items = append(items, v)
+		return true
+	})
+	for _, v := range items {
+		if item, found := t.find(v); found {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+		t.dirty.Delete(v)
+	}
+	return nil
+}
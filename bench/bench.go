@@ -0,0 +1,216 @@
+// Package bench is a reusable set of benchmarks for btree.Tree, so that
+// the Tree can be measured with your own key types on your own hardware.
+// Every benchmark is also run against a Go map holding the same keys
+// to give the numbers some context.
+//
+// To use it, add a benchmark to a _test.go file in your own package:
+//
+//	func BenchmarkMyKeys(b *testing.B) {
+//		bench.Run(b, bench.Config[MyKey]{
+//			Key:  func(i int) MyKey { return MyKey{ID: i} },
+//			Less: func(a, b MyKey) bool { return a.ID < b.ID },
+//		})
+//	}
+//
+// and run it with go test -bench MyKeys.
+package bench
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/VictorLowther/btree"
+)
+
+// DefaultSizes are the Tree sizes used when Config.Sizes is empty.
+var DefaultSizes = []int{1 << 10, 1 << 16, 1 << 20}
+
+// Config describes the keys to benchmark with.
+type Config[T comparable] struct {
+	// Sizes are the numbers of keys to benchmark with.
+	// If it is empty, DefaultSizes is used.
+	Sizes []int
+	// Key returns the i'th key.  Different values of i must produce different keys.
+	Key func(i int) T
+	// Less orders the keys for the Tree.
+	Less btree.LessThan[T]
+	// Seed is used to shuffle the keys before they are used.  The same
+	// Seed always produces the same order, so that results from different
+	// runs and different machines can be compared.
+	Seed int64
+}
+
+// Ints is a Config.Key for int keys.
+func Ints(i int) int { return i }
+
+// Strings is a Config.Key for string keys.  The keys are 16 bytes
+// long and do not sort in the same order as i.
+func Strings(i int) string {
+	return fmt.Sprintf("%016x", uint64(i)*0x9e3779b97f4a7c15)
+}
+
+func (c Config[T]) keys(size int) []T {
+	res := make([]T, size)
+	for i := range res {
+		res[i] = c.Key(i)
+	}
+	rand.New(rand.NewSource(c.Seed)).Shuffle(size, func(i, j int) { res[i], res[j] = res[j], res[i] })
+	return res
+}
+
+func (c Config[T]) tree(keys []T) *btree.Tree[T] {
+	res := btree.New(c.Less)
+	for _, k := range keys {
+		res.Insert(k)
+	}
+	return res
+}
+
+func buildMap[T comparable](keys []T) map[T]struct{} {
+	res := make(map[T]struct{}, len(keys))
+	for _, k := range keys {
+		res[k] = struct{}{}
+	}
+	return res
+}
+
+// Run runs the benchmarks for each size in c as sub-benchmarks of b.
+// Each operation works on one key, and keys are used in the shuffled order.
+func Run[T comparable](b *testing.B, c Config[T]) {
+	sizes := c.Sizes
+	if len(sizes) == 0 {
+		sizes = DefaultSizes
+	}
+	for _, size := range sizes {
+		keys := c.keys(size)
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			b.Run("btree/insert", func(b *testing.B) { c.insert(b, keys) })
+			b.Run("map/insert", func(b *testing.B) { mapInsert(b, keys) })
+			b.Run("btree/get", func(b *testing.B) { c.get(b, keys) })
+			b.Run("map/get", func(b *testing.B) { mapGet(b, keys) })
+			b.Run("btree/delete", func(b *testing.B) { c.delete(b, keys) })
+			b.Run("map/delete", func(b *testing.B) { mapDelete(b, keys) })
+			b.Run("btree/iterate", func(b *testing.B) { c.iterate(b, keys) })
+			b.Run("map/iterate", func(b *testing.B) { mapIterate(b, keys) })
+		})
+	}
+}
+
+// insert starts over with an empty Tree every time all the keys have been inserted.
+func (c Config[T]) insert(b *testing.B, keys []T) {
+	b.ReportAllocs()
+	tree := btree.New(c.Less)
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%len(keys) == 0 {
+			b.StopTimer()
+			tree.Release()
+			tree = btree.New(c.Less)
+			b.StartTimer()
+		}
+		tree.Insert(keys[i%len(keys)])
+	}
+	b.StopTimer()
+	tree.Release()
+}
+
+func mapInsert[T comparable](b *testing.B, keys []T) {
+	b.ReportAllocs()
+	m := map[T]struct{}{}
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%len(keys) == 0 {
+			m = map[T]struct{}{}
+		}
+		m[keys[i%len(keys)]] = struct{}{}
+	}
+}
+
+func (c Config[T]) get(b *testing.B, keys []T) {
+	b.StopTimer()
+	tree := c.tree(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found := tree.Fetch(keys[i%len(keys)]); !found {
+			b.Fatalf("Missing key %v", keys[i%len(keys)])
+		}
+	}
+	b.StopTimer()
+	tree.Release()
+}
+
+func mapGet[T comparable](b *testing.B, keys []T) {
+	b.StopTimer()
+	m := buildMap(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if _, found := m[keys[i%len(keys)]]; !found {
+			b.Fatalf("Missing key %v", keys[i%len(keys)])
+		}
+	}
+}
+
+// delete refills the Tree every time all the keys have been deleted.
+func (c Config[T]) delete(b *testing.B, keys []T) {
+	b.StopTimer()
+	tree := c.tree(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%len(keys) == 0 {
+			b.StopTimer()
+			tree.Release()
+			tree = c.tree(keys)
+			b.StartTimer()
+		}
+		tree.Delete(keys[i%len(keys)])
+	}
+	b.StopTimer()
+	tree.Release()
+}
+
+func mapDelete[T comparable](b *testing.B, keys []T) {
+	b.StopTimer()
+	m := buildMap(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		if i > 0 && i%len(keys) == 0 {
+			b.StopTimer()
+			m = buildMap(keys)
+			b.StartTimer()
+		}
+		delete(m, keys[i%len(keys)])
+	}
+}
+
+// iterate counts visiting one item as an operation.
+func (c Config[T]) iterate(b *testing.B, keys []T) {
+	b.StopTimer()
+	tree := c.tree(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; {
+		tree.Walk(func(T) bool {
+			i++
+			return i < b.N
+		})
+	}
+	b.StopTimer()
+	tree.Release()
+}
+
+func mapIterate[T comparable](b *testing.B, keys []T) {
+	b.StopTimer()
+	m := buildMap(keys)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; {
+		for range m {
+			if i++; i == b.N {
+				break
+			}
+		}
+	}
+}
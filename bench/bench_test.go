@@ -0,0 +1,46 @@
+package bench
+
+import (
+	"testing"
+)
+
+func TestKeys(t *testing.T) {
+	c := Config[string]{Key: Strings, Less: func(a, b string) bool { return a < b }, Seed: 1}
+	keys := c.keys(1000)
+	tree := c.tree(keys)
+	defer tree.Release()
+	if tree.Len() != len(keys) {
+		t.Fatalf("Expected %d unique keys, got %d", len(keys), tree.Len())
+	}
+	again := c.keys(1000)
+	for i := range keys {
+		if keys[i] != again[i] {
+			t.Fatalf("Shuffle is not repeatable at %d", i)
+		}
+	}
+}
+
+func BenchmarkInts(b *testing.B) {
+	Run(b, Config[int]{Key: Ints, Less: func(a, b int) bool { return a < b }})
+}
+
+func BenchmarkStrings(b *testing.B) {
+	Run(b, Config[string]{Key: Strings, Less: func(a, b string) bool { return a < b }})
+}
+
+type structKey struct {
+	Zone string
+	ID   int
+}
+
+func BenchmarkStructs(b *testing.B) {
+	Run(b, Config[structKey]{
+		Key: func(i int) structKey { return structKey{Zone: Strings(i % 16), ID: i} },
+		Less: func(a, b structKey) bool {
+			if a.Zone != b.Zone {
+				return a.Zone < b.Zone
+			}
+			return a.ID < b.ID
+		},
+	})
+}
@@ -1,6 +1,11 @@
 package btree
 
-import "sync"
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
 
 const (
 	Less    = -1
@@ -36,14 +41,41 @@ type CompareAgainst[T any] func(T) int
 // to be equal.
 type LessThan[T any] func(T, T) bool
 
-// Tree is an AVL tree.
+// Tree is an AVL tree.  The zero Tree is ready to use for the builtin integer,
+// float, and string types, which it keeps in ascending order.  Zero Trees of
+// other types must have SetLess called on them before they are used.
 type Tree[T any] struct {
 	root                              *node[T]
+	small                             []T
 	less                              LessThan[T]
+	cmp                               func(T, T) int
 	nodePool                          *sync.Pool
 	insertCount, insertRebalanceCount uint64
 	removeCount, removeRebalanceCount uint64
 	count                             int
+	loader                            Loader[T]
+	flusher                           Flusher[T]
+	flushQ                            chan flushReq[T]
+	flushDone                         chan struct{}
+	access                            *accessTracker[T]
+	deferred                          bool
+	released                          bool
+	quota                             *Quota[T]
+	latency                           *latencyStats
+	logger                            Logger
+	version                           uint64
+	dirty                             *Tree[T]
+	alarms                            *alarmState
+	intern                            func(T) T
+	gate                              func(WriteInfo) error
+	recycle                           *recycleBin[T]
+	yieldEvery                        int
+	yield                             func()
+	guard                             *depthGuard
+	comparator                        string
+	domain                            func(T) error
+	clock                             Clock
+	hitters                           *heavyHitters[T]
 }
 
 // New allocates a new Tree that will keep itself ordered according to the passed in LessThan.
@@ -54,9 +86,74 @@ func New[T any](lt LessThan[T]) *Tree[T] {
 	return res
 }
 
+// NewCmp allocates a new Tree that will keep itself ordered according to cmp,
+// which must return a negative number if a < b, a positive number if a > b,
+// and 0 if a and b are equal, just like strings.Compare does.
+// Trees made with NewCmp only need one call to cmp per level of the Tree
+// when looking for items, which makes them faster than Trees made with New
+// when comparing items is expensive.
+func NewCmp[T any](cmp func(a, b T) int) *Tree[T] {
+	res := New[T](func(a, b T) bool { return cmp(a, b) < 0 })
+	res.cmp = cmp
+	return res
+}
+
+// NewString allocates a new Tree of strings in ascending order.
+// It is NewCmp with strings.Compare, so looking for an item makes one
+// comparison per level of the Tree.
+func NewString() *Tree[string] {
+	return NewCmp[string](strings.Compare)
+}
+
+// NewBytes allocates a new Tree of byte slices in ascending order.
+// Like NewString, it is NewCmp with bytes.Compare.
+// The byte slices must not be modified while they are in the Tree.
+func NewBytes() *Tree[[]byte] {
+	return NewCmp[[]byte](bytes.Compare)
+}
+
+// NewSelfOrdered allocates a new Tree of items that order themselves
+// with a Less method, such as netip.Addr.
+func NewSelfOrdered[T interface{ Less(T) bool }]() *Tree[T] {
+	return New[T](func(a, b T) bool { return a.Less(b) })
+}
+
+// NewComparable allocates a new Tree of items that order themselves with
+// a Compare method that works like the cmp function passed to NewCmp.
+// Like Trees made with NewCmp, they only need one Compare call per level
+// of the Tree when looking for items.
+func NewComparable[T interface{ Compare(T) int }]() *Tree[T] {
+	return NewCmp[T](func(a, b T) int { return a.Compare(b) })
+}
+
+// Less returns the LessThan the Tree is ordered by.
+func (t *Tree[T]) Less() LessThan[T] {
+	t.mustInit()
+	return t.less
+}
+
+// Comparator returns the name of the Tree's ordering set by SetComparator,
+// or an empty string if the ordering has not been named.
+func (t *Tree[T]) Comparator() string { return t.comparator }
+
+// SetComparator names the Tree's ordering, and returns the Tree so it can
+// be chained onto a constructor.  Trees made from t with Copy or Clone get
+// the same name, and Reverse adds or removes a leading - from it.
+// Generic code can use the name to tell whether two Trees are ordered the same way,
+// and CheckCompatible treats Trees with different names as incompatible.
+// Trees are never named unless SetComparator is called.
+func (t *Tree[T]) SetComparator(name string) *Tree[T] {
+	t.comparator = name
+	return t
+}
+
 // Cmp takes a reference T and makes a valid CompareAgainst
 // using the tree's current LessThan comparator.
 func (t *Tree[T]) Cmp(reference T) CompareAgainst[T] {
+	t.mustInit()
+	if t.cmp != nil {
+		return CmpFunc(reference, t.cmp)
+	}
 	less := t.less
 	return func(treeVal T) int {
 		if less(treeVal, reference) {
@@ -72,12 +169,31 @@ func (t *Tree[T]) Cmp(reference T) CompareAgainst[T] {
 // Release caches the memory that the Tree refers to for later reuse.
 // You must not reuse any part of the Tree after calling Release.
 func (t *Tree[T]) Release() {
-	t.count = 0
-	t.less = nil
-	if t.root != nil {
+	t.stopFlusher()
+	t.access = nil
+	t.hitters = nil
+	t.latency = nil
+	t.logger = nil
+	t.alarms = nil
+	t.gate = nil
+	t.domain = nil
+	t.loader = nil
+	if t.dirty != nil {
+		t.dirty.Release()
+		t.dirty = nil
+	}
+	if t.recycle != nil {
+		t.recycle.release()
+		t.recycle = nil
+	}
+	if t.grow() != nil {
 		t.releaseNodes(t.root)
 		t.root = nil
 	}
+	t.count = 0
+	t.less = nil
+	t.cmp = nil
+	t.released = true
 }
 
 // Reverse reverses a Tree in-place by swizzling the pointers in the nodes
@@ -85,8 +201,32 @@ func (t *Tree[T]) Release() {
 // make a copy of the tree and resort the data.  If you want to do that,
 // make a Clone of the Tree and Reverse that.
 func (t *Tree[T]) Reverse() {
+	t.mustInit()
 	ll := t.less
 	t.less = func(a, b T) bool { return ll(b, a) }
+	t.version++
+	if t.hitters != nil {
+		t.hitters.reorder(t.less)
+	}
+	if t.comparator != "" {
+		if strings.HasPrefix(t.comparator, "-") {
+			t.comparator = t.comparator[1:]
+		} else {
+			t.comparator = "-" + t.comparator
+		}
+	}
+	if cmp := t.cmp; cmp != nil {
+		t.cmp = func(a, b T) int { return cmp(b, a) }
+	}
+	if t.dirty != nil {
+		t.dirty.Reverse()
+	}
+	if t.small != nil {
+		for i, j := 0, len(t.small)-1; i < j; i, j = i+1, j-1 {
+			t.small[i], t.small[j] = t.small[j], t.small[i]
+		}
+		return
+	}
 	if t.root == nil {
 		return
 	}
@@ -101,19 +241,59 @@ func (t *Tree[T]) Reverse() {
 	n.r, n.l = n.l, n.r
 }
 
+// DeferRebalance stops Insert and Delete from rebalancing the Tree until
+// Rebalance is called.  This makes bursts of inserts and deletes
+// cheaper at the cost of slower lookups until the Tree is rebalanced.
+// If the Tree gets far enough out of shape that lookups would degrade badly,
+// as happens when inserting items in sorted order, Insert will call
+// Rebalance on its own.
+func (t *Tree[T]) DeferRebalance() {
+	t.deferred = true
+}
+
+// Rebalance rebuilds the Tree into a perfectly balanced shape and
+// resumes rebalancing on every Insert and Delete.  It takes time
+// proportional to the number of items in the Tree.
+func (t *Tree[T]) Rebalance() {
+	t.deferred = false
+	if t.root == nil {
+		return
+	}
+	t.version++
+	nodes := make([]*node[T], 0, t.count)
+	i := t.Iterator(nil, nil)
+	for i.Next() {
+		nodes = append(nodes, i.workingNode)
+	}
+	t.root = relink(nodes)
+	t.root.p = nil
+}
+
 // Copy makes a new copy of the Tree that has the same ordering function
 // but no data.  Trees created using Copy (or any functions that use it)
 // use the same sync.Pool of nodes.
 func (t *Tree[T]) Copy() *Tree[T] {
+	t.mustInit()
 	res := New[T](t.less)
+	res.cmp = t.cmp
 	res.nodePool = t.nodePool
+	res.intern = t.intern
+	res.comparator = t.comparator
+	res.clock = t.clock
 	return res
 }
 
 // Clone makes a full copy of the Tree, including all data.
 func (t *Tree[T]) Clone() *Tree[T] {
 	res := t.Copy()
-	res.root = t.copyNodes(t.root, res)
+	if t.small != nil {
+		for _, v := range t.small {
+			res.insertSmall(v)
+		}
+	} else {
+		res.root = t.copyNodes(t.root, res)
+	}
+	res.deferred = t.deferred
 	return res
 }
 
@@ -122,6 +302,7 @@ func (t *Tree[T]) Clone() *Tree[T] {
 // This (and SortedClone) can be used to implement trees that will maintain items in
 // arbitrarily complicated sort orders.
 func (t *Tree[T]) SortBy(l LessThan[T]) *Tree[T] {
+	t.mustInit()
 	prevLess := t.less
 	res := New[T](func(a, b T) bool {
 		switch {
@@ -134,6 +315,7 @@ func (t *Tree[T]) SortBy(l LessThan[T]) *Tree[T] {
 		}
 	})
 	res.nodePool = t.nodePool
+	res.intern = t.intern
 	return res
 }
 
@@ -150,16 +332,38 @@ func (t *Tree[T]) SortedClone(l LessThan[T]) *Tree[T] {
 // Len returns the number of nodes in the tree.
 func (t *Tree[T]) Len() int { return t.count }
 
+// Version returns a number that changes every time the Tree is modified,
+// so that caches of values computed from the Tree can cheaply tell when
+// they are out of date.  Reading from the Tree does not change it.
+func (t *Tree[T]) Version() uint64 { return t.version }
+
 const unorderable = `Unorderable CompareAgainst passed to Get`
 
 // Get returns either the highest item in the tree that is equal to CompareAgainst and true,
 // or a zero T and false if there is no such value in the Tree.
+// If the Tree has a Loader, Get will ask it for the item before giving up,
+// and will add the item it finds to the Tree (see SetLoader).
 // The Tree must be sorted at the top level in the order that CompareAgainst expects, or you
 // will get nonsense results.  If you want to retrieve all
 // the items matching CompareAgainst, use one of the Range, Before, or After instead.
 func (t *Tree[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
-	h := t.root
-	for h != nil {
+	if t.latency != nil {
+		defer t.latency.get.record(time.Now())
+	}
+	if t.small != nil {
+		if i, ok := t.getSmall(cmp); ok {
+			item, found = t.small[i], true
+			if t.hitters != nil {
+				t.hitters.accessed(item)
+			}
+			return
+		}
+	}
+	h, limit := t.root, t.depthLimit()
+	for depth := 0; h != nil; depth++ {
+		if depth == limit {
+			t.tooDeep(limit)
+		}
 		switch cmp(h.i) {
 		case Greater:
 			h = h.l
@@ -167,11 +371,33 @@ func (t *Tree[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
 			h = h.r
 		case Equal:
 			item, found = h.i, true
+			if t.access != nil {
+				t.access.accessed(h)
+			}
+			if t.hitters != nil {
+				t.hitters.accessed(h.i)
+			}
 			return
 		default:
 			panic(unorderable)
 		}
 	}
+	if t.loader != nil {
+		item, found = t.load(cmp)
+	}
+	return
+}
+
+// GetE is like Get, but returns ErrNotFound if there is no item matching cmp,
+// and ErrReleased if the Tree has been released.
+func (t *Tree[T]) GetE(cmp CompareAgainst[T]) (item T, err error) {
+	if err = t.init(); err != nil {
+		return item, err
+	}
+	found := false
+	if item, found = t.Get(cmp); !found {
+		err = ErrNotFound
+	}
 	return
 }
 
@@ -181,19 +407,62 @@ func (t *Tree[T]) Has(cmp CompareAgainst[T]) bool {
 	return found
 }
 
+// Nearest returns the item in the Tree that is closest to ref and true, or a zero T and false
+// if the Tree is empty.  An item equal to ref is always returned if there is one.  Otherwise,
+// distance is used to decide whether the largest item less than ref or the smallest
+// item greater than ref is closer, and the smaller one wins ties.  If only one of those exists, it is returned.
+// Nearest finds both candidates in a single descent of the Tree.
+func (t *Tree[T]) Nearest(ref T, distance func(a, b T) float64) (item T, found bool) {
+	cmp := t.Cmp(ref)
+	var floor, ceil *node[T]
+	for h := t.view(); h != nil; {
+		switch cmp(h.i) {
+		case Less:
+			floor, h = h, h.r
+		case Greater:
+			ceil, h = h, h.l
+		default:
+			return h.i, true
+		}
+	}
+	switch {
+	case floor == nil && ceil == nil:
+		return
+	case ceil == nil || (floor != nil && distance(floor.i, ref) <= distance(ceil.i, ref)):
+		return floor.i, true
+	default:
+		return ceil.i, true
+	}
+}
+
 // Fetch returns the exact match for item, true if it is in the tree,
 // or the zero value for T, false if it is not.
 func (t *Tree[T]) Fetch(item T) (v T, found bool) {
-	if n, dir := t.getExact(t.root, item); dir == Equal {
+	if t.latency != nil {
+		defer t.latency.get.record(time.Now())
+	}
+	if t.small != nil {
+		var i int
+		if i, found = t.searchSmall(item); found {
+			v = t.small[i]
+		}
+	} else if n, dir := t.getExact(t.root, item); n != nil && dir == Equal {
 		v, found = n.i, true
+		if t.access != nil {
+			t.access.accessed(n)
+		}
+	}
+	if found && t.hitters != nil {
+		t.hitters.accessed(v)
 	}
 	return
 }
 
 // Min returns the smallest item in the Tree and true, or a zero T and false if the tree is empty.
 func (t *Tree[T]) Min() (item T, found bool) {
-	if t.root != nil {
-		found = true
+	if found = t.count > 0; found && t.small != nil {
+		item = t.small[0]
+	} else if found {
 		item = min(t.root).i
 	}
 	return
@@ -201,29 +470,216 @@ func (t *Tree[T]) Min() (item T, found bool) {
 
 // Max returns the largest item in the Tree and true, or a zero T and false if the tree is empty.
 func (t *Tree[T]) Max() (item T, found bool) {
-	if t.root != nil {
-		found = true
+	if found = t.count > 0; found && t.small != nil {
+		item = t.small[len(t.small)-1]
+	} else if found {
 		item = max(t.root).i
 	}
 	return
 }
 
+// MinIn returns the smallest item within the bounds set by start and stop
+// (as with Range) and true, or a zero T and false if there are no items in range.
+// It takes O(log n) time.
+func (t *Tree[T]) MinIn(start, stop Test[T]) (item T, found bool) {
+	scan(t.view(), start, stop, func(v T) bool {
+		item, found = v, true
+		return false
+	})
+	return
+}
+
+// MaxIn returns the largest item within the bounds set by start and stop
+// (as with Range) and true, or a zero T and false if there are no items in range.
+// It takes O(log n) time.
+func (t *Tree[T]) MaxIn(start, stop Test[T]) (item T, found bool) {
+	scanDesc(t.view(), start, stop, func(v T) bool {
+		item, found = v, true
+		return false
+	})
+	return
+}
+
+// At returns the item at index i in ascending order, counting from 0,
+// and true, or a zero T and false if i is out of range.
+// Each node keeps count of the items below it, so At takes O(log n) time.
+func (t *Tree[T]) At(i int) (item T, found bool) {
+	if i < 0 || i >= t.count {
+		return
+	}
+	if t.small != nil {
+		return t.small[i], true
+	}
+	for n := t.root; n != nil; {
+		switch l := n.l.size(); {
+		case i < l:
+			n = n.l
+		case i == l:
+			return n.i, true
+		default:
+			i -= l + 1
+			n = n.r
+		}
+	}
+	return
+}
+
+// Rank returns the number of items in the Tree that are less than the item cmp
+// compares against, and true if the Tree holds an item equal to it.
+// Together with At, it can be used to work out percentiles and page numbers.
+// Like At, Rank takes O(log n) time.
+func (t *Tree[T]) Rank(cmp CompareAgainst[T]) (rank int, found bool) {
+	for h := t.view(); h != nil; {
+		switch c := cmp(h.i); {
+		case c < 0:
+			rank += h.l.size() + 1
+			h = h.r
+		case c > 0:
+			h = h.l
+		default:
+			return rank + h.l.size(), true
+		}
+	}
+	return
+}
+
+// prefixLen returns how many items at the start of the Tree test returns
+// true for.  test must return true for every item before one it returns true for.
+func (t *Tree[T]) prefixLen(test Test[T]) (res int) {
+	for h := t.view(); h != nil; {
+		if test(h.i) {
+			res += h.l.size() + 1
+			h = h.r
+		} else {
+			h = h.l
+		}
+	}
+	return
+}
+
+// countIn returns the number of items between start and stop in O(log n) time.
+func (t *Tree[T]) countIn(start, stop Test[T]) int {
+	lo, hi := 0, t.count
+	if start != nil {
+		lo = t.prefixLen(start)
+	}
+	if stop != nil {
+		hi = t.prefixLen(func(v T) bool { return !stop(v) })
+	}
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
 // Insert an item into the tree. If there is an existing value in the Tree that
 // is equal to item, item will replace that value.
+// If the Tree has a Quota that item would exceed, item may not be inserted.
+// Insert panics if item fails the Tree's domain check (see SetDomain).
+// If the Tree has a write gate, Insert waits for it, but ignores any error
+// it returns (see SetWriteGate).
 func (t *Tree[T]) Insert(item T) {
-	if t.root == nil {
+	t.mustInit()
+	if t.domain != nil {
+		if err := t.domain(item); err != nil {
+			panic(err)
+		}
+	}
+	t.callGate()
+	t.add(item)
+}
+
+// add is Insert after the domain check and the write gate.
+func (t *Tree[T]) add(item T) {
+	if t.latency != nil {
+		defer t.latency.insert.record(time.Now())
+	}
+	if t.quota != nil && !t.quota.admit(t, item) {
+		return
+	}
+	t.insertItem(item)
+	if t.hitters != nil {
+		t.hitters.accessed(item)
+	}
+	if t.logger != nil {
+		t.checkInsert(item)
+	}
+	if t.alarms != nil {
+		t.checkAlarms()
+	}
+	t.flush(item, false)
+}
+
+func (t *Tree[T]) insertItem(item T) {
+	if t.intern != nil {
+		item = t.intern(item)
+	}
+	if t.root == nil && t.access == nil && t.insertSmall(item) {
+		return
+	}
+	if t.grow() == nil {
 		t.root = t.newNode(item)
 	} else {
 		t.insert(item)
 	}
 }
 
+// InsertNew is like Insert, except that it will not replace an existing item.
+// It returns ErrExists if the Tree already has an item equal to item,
+// ErrQuotaExceeded if item would not fit in the Tree's Quota,
+// ErrReleased if the Tree has been released, ErrNoOrdering if the
+// Tree is a zero Tree that SetLess has not been called on, and any error
+// from the Tree's domain check.
+func (t *Tree[T]) InsertNew(item T) error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	if _, found := t.find(item); found {
+		return ErrExists
+	}
+	return t.TryInsert(item)
+}
+
+// CheckBounds returns ErrBoundsInverted if there is an item in the Tree that
+// both start and stop return true for, meaning that the range they describe
+// is inverted and can never contain anything.  It takes O(log n) time.
+func (t *Tree[T]) CheckBounds(start, stop Test[T]) error {
+	if start == nil || stop == nil {
+		return nil
+	}
+	// Find the last item start returns true for.
+	var last *node[T]
+	for h := t.view(); h != nil; {
+		if start(h.i) {
+			last, h = h, h.r
+		} else {
+			h = h.l
+		}
+	}
+	if last != nil && stop(last.i) {
+		return ErrBoundsInverted
+	}
+	return nil
+}
+
 // Delete item from the tree, returning the item deleted
 // or an empty i if the item was not in the tree.
+// If SoftDelete has been called, the deleted item is moved to the recycle bin.
 func (t *Tree[T]) Delete(item T) (deleted T, found bool) {
-	if t.root == nil {
+	if t.latency != nil {
+		defer t.latency.delete.record(time.Now())
+	}
+	if t.count == 0 {
 		return
 	}
-	deleted, found = t.remove(item)
+	if deleted, found = t.remove(item); found {
+		if t.alarms != nil {
+			t.checkAlarms()
+		}
+		if t.recycle != nil {
+			t.recycle.add(t, deleted, t.now())
+		}
+		t.flush(deleted, true)
+	}
 	return
 }
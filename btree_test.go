@@ -1,17 +1,23 @@
 package btree
 
 import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"io"
 	"math"
 	"math/rand"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
-func (n *node[T]) height() uint {
+func (n *node[T]) height() uint8 {
 	if n == nil {
 		return 0
 	}
@@ -73,42 +79,21 @@ func (n *node[T]) balanced(t *testing.T) {
 func newIntTree() (*Tree[int], func(int) CompareAgainst[int]) {
 	tree := New[int](func(a, b int) bool { return a < b })
 	tree.nodePool = intPool
-	return tree,
-		func(v int) CompareAgainst[int] {
-			return func(vv int) int {
-				switch {
-				case vv < v:
-					return -1
-				case vv > v:
-					return 1
-				default:
-					return 0
-				}
-			}
-		}
+	return tree, CmpOrdered[int]
 }
 
 func newStringTree() (*Tree[string], func(string) CompareAgainst[string]) {
 	tree := New[string](func(a, b string) bool { return a < b })
 	tree.nodePool = stringPool
-	return tree,
-		func(v string) CompareAgainst[string] {
-			return func(idx string) int {
-				switch {
-				case idx < v:
-					return -1
-				case idx > v:
-					return 1
-				default:
-					return 0
-				}
-			}
-		}
+	return tree, CmpOrdered[string]
 }
 
 func TestRotate(t *testing.T) {
 	tree := New[int](func(a, b int) bool { return a < b })
 	tree.Insert(1)
+	// Small Trees keep their items in a slice, so move them into nodes
+	// to have rotations to check.
+	tree.grow()
 	tree.Insert(0)
 	tree.Insert(3)
 	tree.Insert(2)
@@ -193,6 +178,9 @@ func TestCases(t *testing.T) {
 	if tree.Has(cmp(1)) {
 		t.Fatalf("not expecting to find key=1")
 	}
+	if _, found := tree.Fetch(1); found {
+		t.Fatalf("not expecting to fetch key=1")
+	}
 
 	tree.Delete(1)
 	if tree.Len() != 0 {
@@ -292,6 +280,11 @@ func TestIterDirection(t *testing.T) {
 			i--
 		}
 	}
+	// Prev must honor start on the first item, even when stop already
+	// leaves nothing for start to allow.
+	if iter := tree.Iterator(Lt(cmp(50)), Gte(cmp(30))); iter.Prev() {
+		t.Fatalf("Prev returned %d from an empty range", iter.Item())
+	}
 	iter := tree.Iterator(nil, nil)
 	i := -1
 	for iter.Next() && i <= 90 {
@@ -793,3 +786,3067 @@ func TestAscendBefore(t *testing.T) {
 		t.Fatalf("expected %v but got %v", expected, ary)
 	}
 }
+
+func TestBuild(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 3, 10, 1000} {
+		src := rand.New(rand.NewSource(int64(n)))
+		items := append(src.Perm(n), src.Perm(n)...)
+		tree := Build[int](items, func(a, b int) bool { return a < b })
+		tree.root.balanced(t)
+		if tree.Len() != n {
+			t.Fatalf("Expected %d items, got %d", n, tree.Len())
+		}
+		j := 0
+		tree.Walk(func(v int) bool {
+			if v != j {
+				t.Fatalf("bad order: expected %d, got %d", j, v)
+			}
+			j++
+			return true
+		})
+		tree.Insert(n)
+		tree.Delete(0)
+		tree.root.balanced(t)
+		tree.Release()
+	}
+}
+
+func TestLoad(t *testing.T) {
+	type rec struct {
+		k, v int
+	}
+	in := "1 a\n\n3 b\r\n2 c\n1 d"
+	dec := func(b []byte) (r rec, err error) {
+		var s string
+		if _, err = fmt.Sscanf(string(b), "%d %s", &r.k, &s); err == nil {
+			r.v = int(s[0])
+		}
+		return
+	}
+	tree, err := Load[rec](strings.NewReader(in), dec, func(a, b rec) bool { return a.k < b.k })
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tree.root.balanced(t)
+	var res []rec
+	tree.Walk(func(r rec) bool {
+		res = append(res, r)
+		return true
+	})
+	expect := []rec{{1, 'd'}, {2, 'c'}, {3, 'b'}}
+	if !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Load failed: expected %v, got %v", expect, res)
+	}
+	// Enough records for several chunks, with duplicates across them.
+	var sb strings.Builder
+	for i := 0; i < 3*loadChunk; i++ {
+		fmt.Fprintf(&sb, "%d %c\n", (i*7919)%(2*loadChunk), 'a'+i/loadChunk)
+	}
+	if tree, err = Load[rec](strings.NewReader(sb.String()), dec, func(a, b rec) bool { return a.k < b.k }); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tree.root.balanced(t)
+	if tree.Len() != 2*loadChunk {
+		t.Fatalf("Expected %d items, got %d", 2*loadChunk, tree.Len())
+	}
+	for i := 2 * loadChunk; i < 3*loadChunk; i++ {
+		if v, _ := tree.Fetch(rec{k: (i * 7919) % (2 * loadChunk)}); v.v != 'c' {
+			t.Fatalf("Expected the last record for %d to win, got %c", v.k, v.v)
+		}
+	}
+	if _, err = Load[rec](strings.NewReader("1 a\nbad"), dec, func(a, b rec) bool { return a.k < b.k }); err == nil {
+		t.Fatalf("Expected error from bad record")
+	} else if !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func BenchmarkBuildIntSeq(b *testing.B) {
+	b.StopTimer()
+	b.ReportAllocs()
+	items := make([]int, b.N)
+	for i := range items {
+		items[i] = i
+	}
+	b.StartTimer()
+	tree := Build[int](items, func(a, b int) bool { return a < b })
+	b.StopTimer()
+	tree.Release()
+}
+
+// intRowsDriver is a minimal database/sql driver whose queries
+// return a single column holding the numbers in the query string.
+type intRowsDriver struct{}
+
+type intRowsConn struct{}
+
+type intRowsStmt struct{ query string }
+
+type intRows struct{ vals []string }
+
+func init() {
+	sql.Register("btreeIntRows", intRowsDriver{})
+}
+
+func (intRowsDriver) Open(string) (driver.Conn, error) { return intRowsConn{}, nil }
+
+func (intRowsConn) Prepare(q string) (driver.Stmt, error) { return intRowsStmt{q}, nil }
+
+func (intRowsConn) Close() error { return nil }
+
+func (intRowsConn) Begin() (driver.Tx, error) { return nil, driver.ErrSkip }
+
+func (intRowsStmt) Close() error { return nil }
+
+func (intRowsStmt) NumInput() int { return 0 }
+
+func (intRowsStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+
+func (s intRowsStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &intRows{strings.Fields(s.query)}, nil
+}
+
+func (*intRows) Columns() []string { return []string{"k"} }
+
+func (*intRows) Close() error { return nil }
+
+func (r *intRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	dest[0], r.vals = r.vals[0], r.vals[1:]
+	return nil
+}
+
+func TestLoadRows(t *testing.T) {
+	db, err := sql.Open("btreeIntRows", "")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	defer db.Close()
+	scan := func(r *sql.Rows) (v int, err error) {
+		err = r.Scan(&v)
+		return
+	}
+	rows, err := db.Query("5 3 9 1 3")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tree, err := LoadRows[int](rows, scan, func(a, b int) bool { return a < b })
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	tree.root.balanced(t)
+	var res []int
+	tree.Walk(func(v int) bool {
+		res = append(res, v)
+		return true
+	})
+	if expect := []int{1, 3, 5, 9}; !reflect.DeepEqual(expect, res) {
+		t.Fatalf("LoadRows failed: expected %v, got %v", expect, res)
+	}
+	if rows, err = db.Query("1 x"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if _, err = LoadRows[int](rows, scan, func(a, b int) bool { return a < b }); err == nil {
+		t.Fatalf("Expected scan error")
+	}
+}
+
+type mapStore struct {
+	items   map[int]int
+	flushed []flushReq[int]
+}
+
+func (m *mapStore) Load(cmp CompareAgainst[int]) (int, bool) {
+	for k := range m.items {
+		if cmp(k) == Equal {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+func (m *mapStore) Flush(item int, deleted bool) {
+	m.flushed = append(m.flushed, flushReq[int]{item: item, deleted: deleted})
+}
+
+func TestLoaderFlusher(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		tree, cmp := newIntTree()
+		store := &mapStore{items: map[int]int{1: 1, 5: 5}}
+		tree.SetLoader(store)
+		tree.SetFlusher(store, async)
+		if v, found := tree.Get(cmp(5)); !found || v != 5 {
+			t.Fatalf("Expected Get to load 5, got %d %v", v, found)
+		}
+		if tree.Len() != 1 {
+			t.Fatalf("Expected loaded item to be inserted")
+		}
+		if tree.Has(cmp(3)) {
+			t.Fatalf("Did not expect to find 3")
+		}
+		tree.Insert(3)
+		tree.Delete(5)
+		tree.Delete(7)
+		tree.SetFlusher(nil, false)
+		expect := []flushReq[int]{{3, false}, {5, true}}
+		if !reflect.DeepEqual(expect, store.flushed) {
+			t.Fatalf("async %v: expected flushes %v, got %v", async, expect, store.flushed)
+		}
+		tree.Release()
+	}
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	tree.SetLoader(&mapStore{items: map[int]int{1: 1, 5: 5}})
+	tree.SetKeyRange(nil, Gte(cmp(3)))
+	if v, found := tree.Get(cmp(5)); !found || v != 5 || tree.Len() != 0 {
+		t.Fatalf("Expected 5 to be loaded but not added, got %d %v", v, found)
+	}
+	if _, found := tree.Get(cmp(1)); !found || tree.Len() != 1 {
+		t.Fatalf("Expected 1 to be loaded and added")
+	}
+}
+
+func TestHotRanges(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	if tree.HotRanges(1) != nil {
+		t.Fatalf("Expected no hot ranges without tracking")
+	}
+	tree.TrackAccess(2)
+	for i := 0; i < 1000; i++ {
+		tree.Get(cmp(60 + i%5))
+	}
+	for i := 0; i < 100; i++ {
+		tree.Fetch(10)
+	}
+	// Deleting items shuffles them between nodes, and the counts must follow.
+	for i := 20; i < 40; i++ {
+		tree.Delete(i)
+	}
+	res := tree.HotRanges(2)
+	if len(res) != 2 {
+		t.Fatalf("Expected 2 hot ranges, got %v", res)
+	}
+	if res[0].Start > 60 || res[0].End < 64 || res[0].Hits != 1000 {
+		t.Fatalf("Unexpected hottest range %v", res[0])
+	}
+	if res[1].Start > 10 || res[1].End < 10 || res[1].Hits != 100 {
+		t.Fatalf("Unexpected second hottest range %v", res[1])
+	}
+	tree.TrackAccess(0)
+	if tree.HotRanges(1) != nil {
+		t.Fatalf("Expected no hot ranges after tracking stopped")
+	}
+}
+
+func TestLRU(t *testing.T) {
+	var evicted []int
+	l := NewLRU[int](func(a, b int) bool { return a < b }, 3, func(v int) { evicted = append(evicted, v) })
+	defer l.Release()
+	_, cmp := newIntTree()
+	for _, v := range []int{5, 1, 3} {
+		l.Insert(v)
+	}
+	l.Get(cmp(5))
+	l.Insert(4)
+	if expect := []int{1}; !reflect.DeepEqual(expect, evicted) {
+		t.Fatalf("Expected evictions %v, got %v", expect, evicted)
+	}
+	l.Insert(3)
+	l.Peek(cmp(5))
+	l.Insert(9)
+	if expect := []int{1, 5}; !reflect.DeepEqual(expect, evicted) {
+		t.Fatalf("Expected evictions %v, got %v", expect, evicted)
+	}
+	var res []int
+	l.Walk(func(v int) bool {
+		res = append(res, v)
+		return true
+	})
+	if expect := []int{3, 4, 9}; !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected contents %v, got %v", expect, res)
+	}
+	if v, found := l.Oldest(); !found || v != 4 {
+		t.Fatalf("Expected oldest to be 4, got %d", v)
+	}
+	if _, found := l.Delete(4); !found || l.Len() != 2 {
+		t.Fatalf("Delete failed")
+	}
+	if v, found := l.Oldest(); !found || v != 3 {
+		t.Fatalf("Expected oldest to be 3, got %d", v)
+	}
+}
+
+func TestDeferRebalance(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	n := 10000
+	src := rand.New(rand.NewSource(0))
+	backing := src.Perm(n)
+	tree.DeferRebalance()
+	for _, v := range backing {
+		tree.Insert(v)
+	}
+	if !tree.deferred {
+		t.Fatalf("Random inserts should not force a rebalance")
+	}
+	for _, v := range backing[:n/2] {
+		if _, found := tree.Delete(v); !found {
+			t.Fatalf("Did not find %d in the tree", v)
+		}
+	}
+	for _, v := range backing[n/2:] {
+		if !tree.Has(cmp(v)) {
+			t.Fatalf("Did not find %d in the tree", v)
+		}
+	}
+	tree.Rebalance()
+	tree.root.balanced(t)
+	if tree.Len() != n/2 {
+		t.Fatalf("Expected %d items, got %d", n/2, tree.Len())
+	}
+	for _, v := range backing[:n/2] {
+		tree.Insert(v)
+		tree.root.balanced(t)
+	}
+	tree.DeferRebalance()
+	for i := n; i < 2*n; i++ {
+		tree.Insert(i)
+	}
+	if tree.deferred {
+		t.Fatalf("Sequential inserts should have forced a rebalance")
+	}
+	tree.root.balanced(t)
+	j := 0
+	tree.Walk(func(v int) bool {
+		if v != j {
+			t.Fatalf("bad order")
+		}
+		j++
+		return true
+	})
+}
+
+func BenchmarkInsertIntRandDeferred(b *testing.B) {
+	b.StopTimer()
+	b.ReportAllocs()
+	seed := time.Now().Unix()
+	tree, _ := newIntTree()
+	defer tree.Release()
+	rs := rand.New(rand.NewSource(seed))
+	backing := rs.Perm(b.N)
+	b.StartTimer()
+	tree.DeferRebalance()
+	for i := 0; i < b.N; i++ {
+		tree.Insert(backing[i])
+	}
+	tree.Rebalance()
+	b.StopTimer()
+}
+
+func TestNodeSize(t *testing.T) {
+	word := unsafe.Sizeof(uintptr(0))
+	// The height packs in next to small items, but the subtree size takes
+	// another word, so node[int32] is 5 words instead of the 4 it was
+	// before At and Rank needed sizes.
+	if sz := unsafe.Sizeof(node[int32]{}); sz != 5*word {
+		t.Fatalf("node[int32] is %d bytes", sz)
+	}
+	// The height and subtree size should share a word instead of taking one each.
+	if sz := unsafe.Sizeof(node[int64]{}); sz != 5*word {
+		t.Fatalf("node[int64] is %d bytes", sz)
+	}
+}
+
+func BenchmarkInt32(b *testing.B) {
+	lt := func(a, b int32) bool { return a < b }
+	for _, sz := range []int{1 << 16, 1 << 22} {
+		b.Run(fmt.Sprintf("insert size %d", sz), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree := New[int32](lt)
+				for j := 0; j < sz; j++ {
+					tree.Insert(int32(j))
+				}
+				tree.Release()
+			}
+		})
+		b.Run(fmt.Sprintf("fetch size %d", sz), func(b *testing.B) {
+			b.StopTimer()
+			tree := New[int32](lt)
+			defer tree.Release()
+			for j := 0; j < sz; j++ {
+				tree.Insert(int32(j))
+			}
+			items := rand.Perm(sz)
+			b.StartTimer()
+			for i := 0; i < b.N; i++ {
+				tree.Fetch(int32(items[i%sz]))
+			}
+		})
+	}
+}
+
+func TestNewCmp(t *testing.T) {
+	tree := NewCmp[string](strings.Compare)
+	defer tree.Release()
+	src := rand.New(rand.NewSource(0))
+	for _, v := range src.Perm(1000) {
+		tree.Insert(fmt.Sprintf("%04d", v))
+		tree.root.balanced(t)
+	}
+	for _, v := range src.Perm(500) {
+		if _, found := tree.Delete(fmt.Sprintf("%04d", v)); !found {
+			t.Fatalf("Did not find %04d in the tree", v)
+		}
+		tree.root.balanced(t)
+	}
+	if tree.Len() != 500 {
+		t.Fatalf("Expected 500 items, got %d", tree.Len())
+	}
+	if v, found := tree.Fetch("0501"); !found || v != "0501" {
+		t.Fatalf("Did not fetch 0501")
+	}
+	if v, found := tree.Get(tree.Cmp("0999")); !found || v != "0999" {
+		t.Fatalf("Did not get 0999")
+	}
+	tree.Reverse()
+	clone := tree.Clone()
+	defer clone.Release()
+	clone.Insert("1000")
+	if v, _ := clone.Min(); v != "1000" {
+		t.Fatalf("Expected reversed clone to start at 1000, not %s", v)
+	}
+	prev := ""
+	clone.Walk(func(v string) bool {
+		if prev != "" && v >= prev {
+			t.Fatalf("bad order: %s after %s", v, prev)
+		}
+		prev = v
+		return true
+	})
+}
+
+func BenchmarkFetchString(b *testing.B) {
+	sz := 1 << 16
+	backing := make([]string, sz)
+	for i := range backing {
+		backing[i] = fmt.Sprintf("a common key prefix/%08d", i)
+	}
+	items := rand.Perm(sz)
+	trees := []*Tree[string]{
+		New[string](func(a, b string) bool { return a < b }),
+		NewCmp[string](strings.Compare),
+		NewString(),
+	}
+	for i, name := range []string{"LessThan", "NewCmp", "NewString"} {
+		tree := trees[i]
+		for _, v := range backing {
+			tree.Insert(v)
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.Fetch(backing[items[i%sz]])
+			}
+		})
+		tree.Release()
+	}
+}
+
+func TestNewBytes(t *testing.T) {
+	tree := NewBytes()
+	defer tree.Release()
+	for _, v := range []string{"b", "", "ab", "a", "abc"} {
+		tree.Insert([]byte(v))
+	}
+	var res []string
+	tree.Walk(func(v []byte) bool {
+		res = append(res, string(v))
+		return true
+	})
+	if expect := []string{"", "a", "ab", "abc", "b"}; !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected %v, got %v", expect, res)
+	}
+	if !tree.Has(tree.Cmp([]byte("ab"))) {
+		t.Fatalf("Did not find ab")
+	}
+}
+
+func BenchmarkInsertBytesRand(b *testing.B) {
+	rs := rand.New(rand.NewSource(time.Now().Unix()))
+	backing := make([][]byte, 1<<16)
+	for i := range backing {
+		backing[i] = make([]byte, 32)
+		rs.Read(backing[i])
+	}
+	for _, tree := range []*Tree[[]byte]{
+		New[[]byte](func(a, b []byte) bool { return string(a) < string(b) }),
+		NewBytes(),
+	} {
+		name := "LessThan"
+		if tree.cmp != nil {
+			name = "NewBytes"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				tree.Insert(backing[i%len(backing)])
+			}
+		})
+		tree.Release()
+	}
+}
+
+func TestForest(t *testing.T) {
+	f := NewForest[int]()
+	f.Register("asc", func(a, b int) bool { return a < b })
+	f.RegisterCmp("desc", func(a, b int) int { return b - a })
+	asc, desc := f.New("asc"), f.New("desc")
+	if asc.nodePool != desc.nodePool {
+		t.Fatalf("Trees in a Forest should share a node pool")
+	}
+	for i := 0; i < 10; i++ {
+		asc.Insert(i)
+		desc.Insert(i)
+	}
+	desc.Delete(3)
+	if v, _ := asc.Min(); v != 0 {
+		t.Fatalf("Expected asc to start at 0, not %d", v)
+	}
+	if v, _ := desc.Min(); v != 9 {
+		t.Fatalf("Expected desc to start at 9, not %d", v)
+	}
+	expect := ForestStats{Trees: 2, Items: 19, Inserts: 20, Deletes: 1}
+	if st := f.Stats(); st != expect {
+		t.Fatalf("Expected stats %v, got %v", expect, st)
+	}
+	f.Drop(desc)
+	if f.Len() != 1 || desc.Len() != 0 {
+		t.Fatalf("Drop failed")
+	}
+	f.Release()
+	if f.Len() != 0 || asc.Len() != 0 {
+		t.Fatalf("Release failed")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected New with an unregistered name to panic")
+		}
+	}()
+	f.New("nope")
+}
+
+func TestQuota(t *testing.T) {
+	f := NewForest[string]()
+	f.RegisterCmp("str", strings.Compare)
+	a, b := f.New("str"), f.New("str")
+	a.Insert("pre-existing")
+	var rejected []string
+	q := &Quota[string]{
+		MaxItems: 4,
+		MaxBytes: 20,
+		Size:     func(s string) int64 { return int64(len(s)) },
+		OnExceed: func(_ *Tree[string], s string) bool {
+			rejected = append(rejected, s)
+			return false
+		},
+	}
+	f.SetQuota(a, q)
+	f.SetQuota(b, q)
+	if q.Items() != 1 || q.Bytes() != 12 {
+		t.Fatalf("Expected 1 item and 12 bytes, got %d and %d", q.Items(), q.Bytes())
+	}
+	b.Insert("abc")
+	b.Insert("abcdef")
+	if q.Items() != 2 || q.Bytes() != 15 {
+		t.Fatalf("Expected 2 items and 15 bytes, got %d and %d", q.Items(), q.Bytes())
+	}
+	if expect := []string{"abcdef"}; !reflect.DeepEqual(expect, rejected) {
+		t.Fatalf("Expected rejections %v, got %v", expect, rejected)
+	}
+	if err := b.TryInsert("abcdef"); err != ErrQuotaExceeded {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	for _, s := range []string{"a", "b"} {
+		if err := b.TryInsert(s); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := a.TryInsert("c"); err != ErrQuotaExceeded {
+		t.Fatalf("Expected item quota to be hit, got %v", err)
+	}
+	// Replacing an equal item does not use up another item.
+	if err := b.TryInsert("a"); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	b.Delete("abc")
+	if q.Items() != 3 || q.Bytes() != 14 {
+		t.Fatalf("Expected 3 items and 14 bytes, got %d and %d", q.Items(), q.Bytes())
+	}
+	f.Drop(a)
+	if q.Items() != 2 || q.Bytes() != 2 {
+		t.Fatalf("Expected 2 items and 2 bytes, got %d and %d", q.Items(), q.Bytes())
+	}
+	f.SetQuota(b, nil)
+	if q.Items() != 0 || q.Bytes() != 0 {
+		t.Fatalf("Expected empty quota, got %d items and %d bytes", q.Items(), q.Bytes())
+	}
+	f.Release()
+}
+
+func TestMerge3(t *testing.T) {
+	type kv struct{ k, v int }
+	lt := func(a, b kv) bool { return a.k < b.k }
+	mk := func(items ...kv) *Tree[kv] {
+		res := New[kv](lt)
+		for _, i := range items {
+			res.Insert(i)
+		}
+		return res
+	}
+	base := mk(kv{1, 1}, kv{2, 2}, kv{3, 3}, kv{4, 4}, kv{5, 5})
+	mine := mk(kv{1, 1}, kv{2, 20}, kv{3, 3}, kv{6, 6})
+	theirs := mk(kv{1, 1}, kv{2, 2}, kv{3, 30}, kv{5, 5}, kv{7, 7})
+	merged, conflicts := Merge3(base, mine, theirs, func(b, m, t kv) (kv, bool) {
+		switch {
+		case m == t || t == b:
+			return m, true
+		case m == b:
+			return t, true
+		default:
+			return kv{}, false
+		}
+	})
+	merged.root.balanced(t)
+	var res []kv
+	merged.Walk(func(i kv) bool {
+		res = append(res, i)
+		return true
+	})
+	if expect := []kv{{1, 1}, {2, 20}, {3, 30}, {6, 6}, {7, 7}}; !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected merge %v, got %v", expect, res)
+	}
+	expect := []Conflict[kv]{{Base: kv{5, 5}, Theirs: kv{5, 5}, InBase: true, InTheirs: true}}
+	if !reflect.DeepEqual(expect, conflicts) {
+		t.Fatalf("Expected conflicts %v, got %v", expect, conflicts)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected Merge3 to panic when resolve changes the key")
+		}
+	}()
+	Merge3(base, mine, theirs, func(b, m, t kv) (kv, bool) { return kv{m.k * 10, m.v}, true })
+}
+
+func TestUnion(t *testing.T) {
+	type kv struct{ k, v int }
+	a, b := New[kv](func(a, b kv) bool { return a.k < b.k }), New[kv](func(a, b kv) bool { return a.k < b.k })
+	for i := 0; i < 10; i += 2 {
+		a.Insert(kv{i, 1})
+	}
+	for i := 0; i < 10; i += 3 {
+		b.Insert(kv{i, 2})
+	}
+	u := a.Union(b, func(x, y kv) kv { return kv{x.k, x.v + y.v} })
+	u.root.balanced(t)
+	var res []kv
+	u.Walk(func(i kv) bool {
+		res = append(res, i)
+		return true
+	})
+	expect := []kv{{0, 3}, {2, 1}, {3, 2}, {4, 1}, {6, 3}, {8, 1}, {9, 2}}
+	if !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected union %v, got %v", expect, res)
+	}
+	if v, _ := a.Union(b, nil).Min(); v != (kv{0, 1}) {
+		t.Fatalf("Expected items from the receiver to win, got %v", v)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected Union to panic when resolve changes the key")
+		}
+	}()
+	a.Union(b, func(x, y kv) kv { return kv{x.k + 100, x.v} })
+}
+
+func TestIntersection(t *testing.T) {
+	type kv struct{ k, v int }
+	a, b := New[kv](func(a, b kv) bool { return a.k < b.k }), New[kv](func(a, b kv) bool { return a.k < b.k })
+	for i := 0; i < 20; i += 2 {
+		a.Insert(kv{i, 1})
+	}
+	for i := 0; i < 20; i += 3 {
+		b.Insert(kv{i, 2})
+	}
+	in := a.Intersection(b, func(x, y kv) kv { return kv{x.k, x.v + y.v} })
+	in.root.balanced(t)
+	var res []kv
+	in.Walk(func(i kv) bool {
+		res = append(res, i)
+		return true
+	})
+	expect := []kv{{0, 3}, {6, 3}, {12, 3}, {18, 3}}
+	if !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected intersection %v, got %v", expect, res)
+	}
+	if v, _ := b.Intersection(a, nil).Max(); v != (kv{18, 2}) {
+		t.Fatalf("Expected items from the receiver to win, got %v", v)
+	}
+	if a.Intersection(New[kv](a.less), nil).Len() != 0 {
+		t.Fatalf("Expected an empty intersection")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected Intersection to panic when resolve changes the key")
+		}
+	}()
+	a.Intersection(b, func(x, y kv) kv { return kv{-x.k, x.v} })
+}
+
+func TestExcept(t *testing.T) {
+	a, _ := newIntTree()
+	b, _ := newIntTree()
+	for i := 0; i < 20; i++ {
+		a.Insert(i)
+	}
+	for i := -5; i < 25; i += 3 {
+		b.Insert(i)
+	}
+	var res []int
+	e := a.ExceptIterator(b)
+	for e.Next() {
+		res = append(res, e.Item())
+	}
+	if e.Next() {
+		t.Fatalf("Finished ExceptIterator should stay finished")
+	}
+	expect := []int{0, 2, 3, 5, 6, 8, 9, 11, 12, 14, 15, 17, 18}
+	if !reflect.DeepEqual(expect, res) {
+		t.Fatalf("Expected %v, got %v", expect, res)
+	}
+	ex := a.Except(b)
+	ex.root.balanced(t)
+	if ex.Len() != len(expect) {
+		t.Fatalf("Expected %d items, got %d", len(expect), ex.Len())
+	}
+	if a.Except(a).Len() != 0 {
+		t.Fatalf("Expected a tree minus itself to be empty")
+	}
+}
+
+func TestContains(t *testing.T) {
+	a, _ := newIntTree()
+	b, _ := newIntTree()
+	c, _ := newIntTree()
+	for i := 0; i < 20; i++ {
+		a.Insert(i)
+	}
+	for i := 0; i < 20; i += 3 {
+		b.Insert(i)
+	}
+	for i := 20; i < 30; i++ {
+		c.Insert(i)
+	}
+	if !a.ContainsAll(b) || b.ContainsAll(a) {
+		t.Fatalf("ContainsAll failed")
+	}
+	if !a.ContainsAll(New[int](a.less)) {
+		t.Fatalf("Everything contains the empty tree")
+	}
+	b.Insert(25)
+	if a.ContainsAll(b) {
+		t.Fatalf("ContainsAll should have failed on 25")
+	}
+	if !a.ContainsAny(b) || !b.ContainsAny(c) || a.ContainsAny(c) {
+		t.Fatalf("ContainsAny failed")
+	}
+}
+
+func TestSpatial(t *testing.T) {
+	s := NewSpatial[Point](func(p Point) Point { return p }, nil)
+	defer s.Release()
+	for x := uint32(0); x < 64; x++ {
+		for y := uint32(0); y < 64; y++ {
+			s.Insert(Point{x, y})
+		}
+	}
+	if (Point{3, 5}).ZOrder() != 0x27 {
+		t.Fatalf("Bad ZOrder for 3,5: %x", Point{3, 5}.ZOrder())
+	}
+	src := rand.New(rand.NewSource(0))
+	for i := 0; i < 100; i++ {
+		min := Point{uint32(src.Intn(64)), uint32(src.Intn(64))}
+		max := Point{uint32(src.Intn(64)), uint32(src.Intn(64))}
+		found := map[Point]bool{}
+		s.Within(min, max, func(p Point) bool {
+			if found[p] {
+				t.Fatalf("Saw %v twice", p)
+			}
+			found[p] = true
+			return true
+		})
+		if min.X > max.X {
+			min.X, max.X = max.X, min.X
+		}
+		if min.Y > max.Y {
+			min.Y, max.Y = max.Y, min.Y
+		}
+		if expect := int((max.X - min.X + 1) * (max.Y - min.Y + 1)); len(found) != expect {
+			t.Fatalf("Expected %d points in %v-%v, found %d", expect, min, max, len(found))
+		}
+		for p := range found {
+			if p.X < min.X || p.X > max.X || p.Y < min.Y || p.Y > max.Y {
+				t.Fatalf("%v is not in %v-%v", p, min, max)
+			}
+		}
+	}
+}
+
+func TestTimeSeries(t *testing.T) {
+	type event struct {
+		at time.Time
+		id int
+	}
+	epoch := time.Date(2022, 7, 16, 0, 0, 0, 0, time.UTC)
+	ts := NewTimeSeries[event](func(a, b event) bool { return a.id < b.id },
+		func(e event) time.Time { return e.at }, time.Hour)
+	defer ts.Release()
+	for i := 0; i < 48; i++ {
+		ts.Insert(event{at: epoch.Add(time.Duration(i) * 30 * time.Minute), id: 100 - i})
+	}
+	if ts.Len() != 48 || ts.Buckets() != 24 {
+		t.Fatalf("Expected 48 items in 24 buckets, got %d in %d", ts.Len(), ts.Buckets())
+	}
+	var ids []int
+	ts.Range(epoch.Add(90*time.Minute), epoch.Add(4*time.Hour), func(e event) bool {
+		ids = append(ids, e.id)
+		return true
+	})
+	if expect := []int{93, 94, 95, 96, 97}; !reflect.DeepEqual(expect, ids) {
+		t.Fatalf("Expected %v, got %v", expect, ids)
+	}
+	if _, found := ts.Delete(event{at: epoch, id: 100}); !found {
+		t.Fatalf("Did not delete first event")
+	}
+	if dropped := ts.Expire(epoch.Add(150 * time.Minute)); dropped != 3 {
+		t.Fatalf("Expected 3 items to expire, not %d", dropped)
+	}
+	if ts.Len() != 44 || ts.Buckets() != 22 {
+		t.Fatalf("Expected 44 items in 22 buckets, got %d in %d", ts.Len(), ts.Buckets())
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	tree.Insert(-1)
+	tree.TrackLatency(true)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	for i := 0; i < 50; i++ {
+		tree.Get(cmp(i))
+		tree.Delete(i)
+	}
+	tree.Walk(func(int) bool { return false })
+	st := tree.Stats()
+	if st.Items != 51 || st.Inserts != 101 || st.Deletes != 50 {
+		t.Fatalf("Unexpected counts in %+v", st)
+	}
+	if st.Insert.Count != 100 || st.Delete.Count != 50 || st.Get.Count != 50 || st.Iterate.Count != 1 {
+		t.Fatalf("Unexpected latency counts: %d %d %d %d",
+			st.Insert.Count, st.Delete.Count, st.Get.Count, st.Iterate.Count)
+	}
+	if st.Insert.Mean() > st.Insert.Quantile(1) || st.Insert.Quantile(0.5) > st.Insert.Quantile(1) {
+		t.Fatalf("Inconsistent insert latency: mean %v, p50 %v, max %v",
+			st.Insert.Mean(), st.Insert.Quantile(0.5), st.Insert.Quantile(1))
+	}
+	tree.TrackLatency(false)
+	if tree.Stats().Insert.Count != 0 {
+		t.Fatalf("Expected latencies to be discarded")
+	}
+}
+
+type anomalyLog []Anomaly
+
+func (a *anomalyLog) LogAnomaly(an Anomaly) { *a = append(*a, an) }
+
+func TestAnomalies(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	var log anomalyLog
+	tree.SetLogger(&log)
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	iter := tree.Iterator(nil, nil)
+	iter.Next()
+	iter.Next()
+	if len(log) != 0 {
+		t.Fatalf("Unexpected anomalies %v", log)
+	}
+	tree.Delete(50)
+	iter.Next()
+	iter.Next()
+	if len(log) != 1 || log[0].Kind != AnomalyModifiedDuringIteration || log[0].Len != 99 {
+		t.Fatalf("Expected one modified during iteration anomaly, got %v", log)
+	}
+	log = nil
+	// Build a degenerate tree behind the Tree's back.
+	tree.DeferRebalance()
+	for i := 100; i < 110; i++ {
+		tree.Insert(i)
+	}
+	tree.deferred = false
+	tree.Insert(110)
+	if len(log) != 1 || log[0].Kind != AnomalyTooDeep {
+		t.Fatalf("Expected one too deep anomaly, got %v", log)
+	}
+	log = nil
+	bad := New[int](func(a, b int) bool { return a <= b })
+	bad.SetLogger(&log)
+	bad.Insert(1)
+	if len(log) != 1 || log[0].Kind != AnomalyComparator {
+		t.Fatalf("Expected one comparator anomaly, got %v", log)
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	if r := tree.HealthReport(); r.Err != nil || r.Height != 0 || r.HeightRatio != 1 {
+		t.Fatalf("Unexpected report for empty tree %+v", r)
+	}
+	for _, v := range rand.Perm(1000) {
+		tree.Insert(v)
+	}
+	r := tree.HealthReport()
+	if r.Err != nil || r.Items != 1000 || r.OptimalHeight != 10 || r.HeightRatio > 1.44 || r.HeightRatio < 1 {
+		t.Fatalf("Unexpected report %+v", r)
+	}
+	orig := tree.root.l.i
+	tree.root.l.i = 5000
+	if err := tree.Validate(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected out of order items to be caught, got %v", err)
+	}
+	tree.root.l.i = orig
+	tree.root.l.p = nil
+	if err := tree.Validate(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected bad parent to be caught, got %v", err)
+	}
+	tree.root.l.p = tree.root
+	tree.count++
+	if err := tree.HealthReport().Err; !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Expected bad count to be caught, got %v", err)
+	}
+	tree.count--
+	if err := tree.Validate(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func TestItemPtr(t *testing.T) {
+	type big struct {
+		k       int
+		payload [32]int
+	}
+	tree := New[big](func(a, b big) bool { return a.k < b.k })
+	defer tree.Release()
+	for i := 0; i < 10; i++ {
+		tree.Insert(big{k: i})
+	}
+	iter := tree.Iterator(nil, nil)
+	for iter.Next() {
+		p := iter.ItemPtr()
+		p.payload[0] = p.k * 2
+	}
+	if v, _ := tree.Fetch(big{k: 4}); v.payload[0] != 8 {
+		t.Fatalf("Expected write through ItemPtr to stick")
+	}
+	iter = tree.Iterator(nil, nil)
+	iter.Next()
+	tree.Delete(big{k: 9})
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected ItemPtr to panic after the tree was modified")
+		}
+	}()
+	iter.ItemPtr()
+}
+
+func TestReduce(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for _, v := range rand.Perm(100) {
+		tree.Insert(v)
+	}
+	sum := func(acc, v int) int { return acc + v }
+	if s := Reduce(tree, nil, nil, 0, sum); s != 4950 {
+		t.Fatalf("Expected sum 4950, got %d", s)
+	}
+	if s := Reduce(tree, Lt(cmp(10)), Gte(cmp(20)), 0, sum); s != 145 {
+		t.Fatalf("Expected sum 145, got %d", s)
+	}
+	if s := Reduce(tree, Lte(cmp(97)), nil, 0, sum); s != 99+98 {
+		t.Fatalf("Expected sum 197, got %d", s)
+	}
+	strs := Reduce(tree, nil, Gt(cmp(2)), "", func(acc string, v int) string { return acc + fmt.Sprint(v) })
+	if strs != "012" {
+		t.Fatalf("Expected 012, got %s", strs)
+	}
+	for _, n := range []int{10, 100} {
+		tree.DeleteRange(nil, nil)
+		for i := 0; i < n; i++ {
+			tree.Insert(i)
+		}
+		if allocs := testing.AllocsPerRun(10, func() { Reduce(tree, nil, nil, 0, sum) }); allocs != 0 {
+			t.Fatalf("Reduce over %d items made %v allocations", n, allocs)
+		}
+	}
+}
+
+func BenchmarkReduce(b *testing.B) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 1<<16; i++ {
+		tree.Insert(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Reduce(tree, nil, nil, 0, func(acc, v int) int { return acc + v })
+	}
+}
+
+func TestFindFirstLast(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for _, v := range rand.Perm(100) {
+		tree.Insert(v)
+	}
+	odd := func(v int) bool { return v%2 == 1 }
+	checks := []struct {
+		start, stop Test[int]
+		first, last int
+		found       bool
+	}{
+		{nil, nil, 1, 99, true},
+		{Lt(cmp(10)), Gt(cmp(20)), 11, 19, true},
+		{Lte(cmp(10)), Gte(cmp(19)), 11, 17, true},
+		{Lt(cmp(50)), Gt(cmp(50)), 0, 0, false},
+		{Lt(cmp(200)), nil, 0, 0, false},
+	}
+	for i, c := range checks {
+		if v, found := tree.FindFirst(c.start, c.stop, odd); v != c.first || found != c.found {
+			t.Fatalf("%d: expected FindFirst to return %d %v, got %d %v", i, c.first, c.found, v, found)
+		}
+		if v, found := tree.FindLast(c.start, c.stop, odd); v != c.last || found != c.found {
+			t.Fatalf("%d: expected FindLast to return %d %v, got %d %v", i, c.last, c.found, v, found)
+		}
+	}
+}
+
+func TestAnyAllNone(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	small := func(v int) bool { return v < 10 }
+	if !tree.Any(nil, nil, small) || tree.All(nil, nil, small) || tree.None(nil, nil, small) {
+		t.Fatalf("Unexpected results over the whole tree")
+	}
+	if !tree.All(nil, Gte(cmp(10)), small) || tree.Any(Lt(cmp(10)), nil, small) || !tree.None(Lt(cmp(10)), nil, small) {
+		t.Fatalf("Unexpected results over bounded ranges")
+	}
+	if !tree.All(Lt(cmp(500)), nil, small) || tree.Any(Lt(cmp(500)), nil, small) {
+		t.Fatalf("Empty ranges should satisfy All and not Any")
+	}
+}
+
+func TestMinMaxIn(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	if _, found := tree.MinIn(nil, nil); found {
+		t.Fatalf("Empty tree has no min")
+	}
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(i)
+	}
+	checks := []struct {
+		start, stop Test[int]
+		min, max    int
+		found       bool
+	}{
+		{nil, nil, 0, 98, true},
+		{Lt(cmp(11)), Gt(cmp(21)), 12, 20, true},
+		{Lte(cmp(10)), Gte(cmp(20)), 12, 18, true},
+		{Lt(cmp(11)), Gt(cmp(11)), 0, 0, false},
+		{Lt(cmp(99)), nil, 0, 0, false},
+		{nil, Gte(cmp(0)), 0, 0, false},
+	}
+	for i, c := range checks {
+		if v, found := tree.MinIn(c.start, c.stop); v != c.min || found != c.found {
+			t.Fatalf("%d: expected MinIn to return %d %v, got %d %v", i, c.min, c.found, v, found)
+		}
+		if v, found := tree.MaxIn(c.start, c.stop); v != c.max || found != c.found {
+			t.Fatalf("%d: expected MaxIn to return %d %v, got %d %v", i, c.max, c.found, v, found)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	tree := New[float64](func(a, b float64) bool { return a < b })
+	defer tree.Release()
+	dist := func(a, b float64) float64 { return math.Abs(a - b) }
+	if _, found := tree.Nearest(1, dist); found {
+		t.Fatalf("Empty tree has nothing near 1")
+	}
+	for _, v := range []float64{1, 2, 4, 8, 16} {
+		tree.Insert(v)
+	}
+	for ref, expect := range map[float64]float64{
+		-5: 1, 1: 1, 2.9: 2, 3: 2, 3.1: 4, 11: 8, 13: 16, 100: 16,
+	} {
+		if v, found := tree.Nearest(ref, dist); !found || v != expect {
+			t.Fatalf("Expected %v to be nearest to %v, got %v", expect, ref, v)
+		}
+	}
+}
+
+func TestFirstGap(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	succ := func(v int) int { return v + 1 }
+	if v := tree.FirstGap(1, succ); v != 1 {
+		t.Fatalf("Expected 1 in an empty tree, got %d", v)
+	}
+	for _, v := range []int{1, 2, 3, 5, 6, 9} {
+		tree.Insert(v)
+	}
+	for from, expect := range map[int]int{0: 0, 1: 4, 4: 4, 5: 7, 8: 8, 9: 10, 20: 20} {
+		if v := tree.FirstGap(from, succ); v != expect {
+			t.Fatalf("Expected first gap from %d to be %d, got %d", from, expect, v)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	for _, n := range []int{100, 1000} {
+		tree, cmp := newIntTree()
+		for i := 0; i < n; i++ {
+			tree.Insert(i)
+		}
+		// A narrow range is deleted item by item, a wide one is relinked.
+		for _, width := range []int{5, n / 2} {
+			lo := n / 4
+			hi := lo + width
+			out := tree.ExtractRange(Lt(cmp(lo)), Gte(cmp(hi)))
+			if err := out.Validate(); err != nil {
+				t.Fatalf("Extracted tree invalid: %v", err)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("Remaining tree invalid: %v", err)
+			}
+			if out.Len() != width || tree.Len() != n-width {
+				t.Fatalf("Expected %d extracted and %d left, got %d and %d", width, n-width, out.Len(), tree.Len())
+			}
+			if v, _ := out.Min(); v != lo {
+				t.Fatalf("Expected extracted items to start at %d, not %d", lo, v)
+			}
+			if tree.Any(Lt(cmp(lo)), Gte(cmp(hi)), func(int) bool { return true }) {
+				t.Fatalf("Items left in extracted range")
+			}
+			for i := lo; i < hi; i++ {
+				tree.Insert(i)
+			}
+			out.Release()
+		}
+		odd := tree.ExtractIf(func(v int) bool { return v%2 == 1 })
+		if odd.Len() != n/2 || tree.Len() != n/2 || tree.Validate() != nil || odd.Validate() != nil {
+			t.Fatalf("ExtractIf failed")
+		}
+		if !tree.None(nil, nil, func(v int) bool { return v%2 == 1 }) || !odd.All(nil, nil, func(v int) bool { return v%2 == 1 }) {
+			t.Fatalf("ExtractIf split items incorrectly")
+		}
+		if e := tree.ExtractIf(func(int) bool { return false }); e.Len() != 0 {
+			t.Fatalf("Expected nothing extracted")
+		}
+		odd.Release()
+		tree.Release()
+	}
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tree, cmp := newIntTree()
+	for i := 0; i < 10; i++ {
+		if err := tree.InsertNew(i); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if err := tree.InsertNew(5); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	if v, err := tree.GetE(cmp(5)); err != nil || v != 5 {
+		t.Fatalf("Expected to get 5, got %d %v", v, err)
+	}
+	if _, err := tree.GetE(cmp(50)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if err := tree.CheckBounds(Lt(cmp(3)), Gt(cmp(6))); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if err := tree.CheckBounds(Lt(cmp(6)), Gt(cmp(3))); !errors.Is(err, ErrBoundsInverted) {
+		t.Fatalf("Expected ErrBoundsInverted, got %v", err)
+	}
+	iter := tree.Iterator(nil, nil)
+	for iter.Next() {
+		if iter.Item() == 3 {
+			tree.Delete(8)
+		}
+	}
+	if err := iter.Err(); !errors.Is(err, ErrModifiedDuringIteration) {
+		t.Fatalf("Expected ErrModifiedDuringIteration, got %v", err)
+	}
+	tree.Release()
+	if err := tree.InsertNew(1); !errors.Is(err, ErrReleased) {
+		t.Fatalf("Expected ErrReleased, got %v", err)
+	}
+	if _, err := tree.GetE(cmp(1)); !errors.Is(err, ErrReleased) {
+		t.Fatalf("Expected ErrReleased, got %v", err)
+	}
+}
+
+func TestCmpHelpers(t *testing.T) {
+	type myInt int
+	c := CmpOrdered[myInt](5)
+	if c(4) != Less || c(5) != Equal || c(6) != Greater {
+		t.Fatalf("CmpOrdered returned the wrong results")
+	}
+	f := CmpFunc("b", strings.Compare)
+	if f("a") != Less || f("b") != Equal || f("c") != Greater {
+		t.Fatalf("CmpFunc returned the wrong results")
+	}
+	tree := NewCmp[int](func(a, b int) int { return (a - b) * 100 })
+	defer tree.Release()
+	tree.Insert(1)
+	if !tree.Has(tree.Cmp(1)) || tree.Has(tree.Cmp(2)) {
+		t.Fatalf("Cmp must normalize three-way comparison results")
+	}
+}
+
+func TestDescending(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for _, i := range []int{4, 6, 1, 3, 9} {
+		tree.Insert(i)
+	}
+	collect := func(f func(Test[int])) (res []int) {
+		f(func(v int) bool {
+			res = append(res, v)
+			return true
+		})
+		return
+	}
+	checks := []struct {
+		res, expect []int
+	}{
+		{collect(func(fn Test[int]) { tree.RangeDesc(Lt(cmp(3)), Gt(cmp(6)), fn) }), []int{6, 4, 3}},
+		{collect(func(fn Test[int]) { tree.RangeDesc(Lte(cmp(3)), Gte(cmp(6)), fn) }), []int{4}},
+		{collect(func(fn Test[int]) { tree.AfterDesc(Lt(cmp(4)), fn) }), []int{9, 6, 4}},
+		{collect(func(fn Test[int]) { tree.BeforeDesc(Gte(cmp(4)), fn) }), []int{3, 1}},
+		{collect(func(fn Test[int]) { tree.BeforeDesc(nil, fn) }), []int{9, 6, 4, 3, 1}},
+		{collect(func(fn Test[int]) { tree.RangeDesc(Lt(cmp(20)), nil, fn) }), nil},
+	}
+	for i, c := range checks {
+		if !reflect.DeepEqual(c.expect, c.res) {
+			t.Fatalf("%d: expected %v, got %v", i, c.expect, c.res)
+		}
+	}
+	// Prev must honor start when picking the first item.
+	iter := tree.Iterator(Lt(cmp(20)), nil)
+	if iter.Prev() {
+		t.Fatalf("Expected no items, got %d", iter.Item())
+	}
+}
+
+func TestPage(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 25; i++ {
+		tree.Insert(i)
+	}
+	var next Test[int]
+	var pages [][]int
+	for more := true; more; {
+		var items []int
+		items, next, more = tree.Page(next, 10)
+		pages = append(pages, items)
+	}
+	if len(pages) != 3 || len(pages[2]) != 5 || pages[1][0] != 10 || pages[2][4] != 24 {
+		t.Fatalf("Unexpected pages: %v", pages)
+	}
+	items, _, more := tree.Page(next, 10)
+	if len(items) != 0 || more {
+		t.Fatalf("Expected empty final page, got %v %v", items, more)
+	}
+	// Exactly filling a page should not report more.
+	if items, _, more = tree.Page(nil, 25); len(items) != 25 || more {
+		t.Fatalf("Expected a single full page, got %d %v", len(items), more)
+	}
+	if items, _, more = tree.Page(nil, 0); len(items) != 0 || more {
+		t.Fatalf("Expected nothing with no limit")
+	}
+}
+
+func TestDirty(t *testing.T) {
+	type kv struct{ k, v int }
+	tree := New[kv](func(a, b kv) bool { return a.k < b.k })
+	defer tree.Release()
+	cmp := func(k int) CompareAgainst[kv] { return tree.Cmp(kv{k: k}) }
+	for i := 0; i < 10; i++ {
+		tree.Insert(kv{i, i})
+	}
+	if tree.DirtyCount() != 0 || tree.FlushDirty(nil) != nil {
+		t.Fatalf("Expected nothing dirty")
+	}
+	for _, k := range []int{7, 2, 5, 3, 2} {
+		if !tree.MarkDirty(cmp(k)) {
+			t.Fatalf("Failed to mark %d", k)
+		}
+	}
+	if tree.MarkDirty(cmp(20)) {
+		t.Fatalf("Marked missing item")
+	}
+	if tree.DirtyCount() != 4 {
+		t.Fatalf("Expected 4 dirty items, got %d", tree.DirtyCount())
+	}
+	tree.Insert(kv{5, 50})
+	tree.Delete(kv{k: 3})
+	if tree.DirtyCount() != 3 {
+		t.Fatalf("Expected Delete to clear a mark, got %d", tree.DirtyCount())
+	}
+	boom := errors.New("boom")
+	var flushed []kv
+	err := tree.FlushDirty(func(v kv) error {
+		if v.k == 7 {
+			return boom
+		}
+		flushed = append(flushed, v)
+		return nil
+	})
+	if err != boom || !reflect.DeepEqual(flushed, []kv{{2, 2}, {5, 50}}) {
+		t.Fatalf("Unexpected flush: %v %v", err, flushed)
+	}
+	if tree.DirtyCount() != 1 {
+		t.Fatalf("Expected failed item to stay dirty, got %d", tree.DirtyCount())
+	}
+	flushed = nil
+	if err = tree.FlushDirty(func(v kv) error { flushed = append(flushed, v); return nil }); err != nil ||
+		!reflect.DeepEqual(flushed, []kv{{7, 7}}) || tree.DirtyCount() != 0 {
+		t.Fatalf("Unexpected second flush: %v %v", err, flushed)
+	}
+}
+
+func TestAlarms(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	var log anomalyLog
+	tree.SetLogger(&log)
+	tree.SetAlarms(&Alarms{MaxHeightRatio: 1.5, MaxRebalanceRate: 1})
+	for _, i := range rand.Perm(alarmCheckEvery) {
+		tree.Insert(i)
+	}
+	if len(log) != 1 || log[0].Kind != AnomalyRebalanceRate {
+		t.Fatalf("Expected one rebalance rate alarm, got %v", log)
+	}
+	if r := tree.HealthReport(); r.RebalanceRate <= 1 || r.HeightRatio > 1.5 {
+		t.Fatalf("Unexpected health report %+v", r)
+	}
+	var alarms []Anomaly
+	tree.SetAlarms(&Alarms{MaxHeightRatio: 1.5, OnAlarm: func(a Anomaly) { alarms = append(alarms, a) }})
+	tree.DeferRebalance()
+	for i := alarmCheckEvery; i < alarmCheckEvery+20; i++ {
+		tree.Insert(i)
+	}
+	// Churn the last item to reach the next check without changing the shape.
+	for i := 0; i < alarmCheckEvery/2; i++ {
+		tree.Delete(alarmCheckEvery + 19)
+		tree.Insert(alarmCheckEvery + 19)
+	}
+	if len(alarms) != 1 || alarms[0].Kind != AnomalyHeightRatio || len(log) != 1 {
+		t.Fatalf("Expected one height ratio alarm, got %v", alarms)
+	}
+	tree.SetAlarms(nil)
+	if tree.HeightRatio() <= 1.5 || tree.HealthReport().RebalanceRate != 0 {
+		t.Fatalf("Unexpected stats after alarms removed")
+	}
+}
+
+func TestIteratorByRank(t *testing.T) {
+	tree, _ := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 50; i++ {
+		tree.Insert(i * 2)
+	}
+	collect := func(iter *Iterator[int]) (res []int) {
+		for iter.Next() {
+			res = append(res, iter.Item())
+		}
+		return
+	}
+	for _, c := range []struct {
+		from, to int
+		expect   []int
+	}{
+		{0, 3, []int{0, 2, 4}},
+		{10, 13, []int{20, 22, 24}},
+		{-5, 1, []int{0}},
+		{47, 100, []int{94, 96, 98}},
+		{5, 5, nil},
+		{50, 60, nil},
+	} {
+		if res := collect(tree.IteratorByRank(c.from, c.to)); !reflect.DeepEqual(res, c.expect) {
+			t.Fatalf("%d-%d: expected %v, got %v", c.from, c.to, c.expect, res)
+		}
+	}
+	iter := tree.IteratorByRank(10, 13)
+	if !iter.Prev() || iter.Item() != 24 {
+		t.Fatalf("Expected Prev to start at rank 12")
+	}
+}
+
+func strData(s string) uintptr {
+	return (*[2]uintptr)(unsafe.Pointer(&s))[0]
+}
+
+func TestInterner(t *testing.T) {
+	in := NewInterner()
+	a, b := NewInternedString(in), NewInternedString(in)
+	defer a.Release()
+	defer b.Release()
+	long := strings.Repeat("x", internChunk)
+	for _, s := range []string{"alpha", "beta", "gamma", long} {
+		a.Insert(strings.Clone(s))
+		b.Insert(strings.Clone(s))
+	}
+	sorted := b.SortBy(func(x, y string) bool { return len(x) < len(y) })
+	defer sorted.Release()
+	sorted.Insert(strings.Clone("beta"))
+	st := in.Stats()
+	if st.Strings != 4 || st.Bytes != uint64(14+len(long)) || st.Saved != uint64(18+len(long)) {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+	for _, s := range []string{"alpha", "beta", "gamma", long} {
+		x, _ := a.Fetch(s)
+		y, _ := b.Fetch(s)
+		z := in.Intern(s)
+		if strData(x) != strData(y) || strData(x) != strData(z) {
+			t.Fatalf("%.10s is not shared", s)
+		}
+	}
+	a.MarkDirty(a.Cmp("beta"))
+	if in.Stats().Saved != st.Saved+uint64(14+len(long)) {
+		t.Fatalf("Dirty tracking should not intern again: %+v", in.Stats())
+	}
+}
+
+type compositeItem struct {
+	Tenant string
+	When   int
+	Tags   []string
+}
+
+func TestCompositeKey(t *testing.T) {
+	key := Composite(
+		Asc(func(c compositeItem) string { return c.Tenant }),
+		Desc(func(c compositeItem) int { return c.When }),
+		AscFunc(func(c compositeItem) []string { return c.Tags }, func(a, b []string) int {
+			return strings.Compare(strings.Join(a, ","), strings.Join(b, ","))
+		}),
+	)
+	tree := key.New()
+	defer tree.Release()
+	items := []compositeItem{
+		{"b", 1, nil}, {"a", 1, nil}, {"a", 3, []string{"y"}}, {"a", 3, []string{"x"}}, {"b", 2, nil},
+	}
+	for _, item := range items {
+		tree.Insert(item)
+	}
+	var got []compositeItem
+	tree.Walk(func(c compositeItem) bool { got = append(got, c); return true })
+	expect := []compositeItem{items[3], items[2], items[1], items[4], items[0]}
+	if !reflect.DeepEqual(got, expect) {
+		t.Fatalf("Expected %v, got %v", expect, got)
+	}
+	for i := range expect {
+		for j := range expect {
+			if key.Less(expect[i], expect[j]) != (i < j) || (key.Compare(expect[i], expect[j]) == 0) != (i == j) {
+				t.Fatalf("Less and Compare disagree about %v and %v", expect[i], expect[j])
+			}
+		}
+	}
+	if v, found := tree.Get(key.Cmp(compositeItem{"b", 2, nil})); !found || v.When != 2 {
+		t.Fatalf("Failed to find item")
+	}
+	rev := Composite(DescFunc(func(c compositeItem) string { return c.Tenant }, strings.Compare))
+	if !rev.Less(items[0], items[1]) {
+		t.Fatalf("DescFunc should sort b before a")
+	}
+}
+
+func TestCompositePrefix(t *testing.T) {
+	key := Composite(
+		Asc(func(c compositeItem) string { return c.Tenant }),
+		Desc(func(c compositeItem) int { return c.When }),
+		Asc(func(c compositeItem) int { return len(c.Tags) }),
+	)
+	tree := key.New()
+	defer tree.Release()
+	for _, tenant := range []string{"a", "b", "c"} {
+		for when := 0; when < 4; when++ {
+			for tags := 0; tags < 2; tags++ {
+				tree.Insert(compositeItem{tenant, when, make([]string, tags)})
+			}
+		}
+	}
+	count := func(ref compositeItem, n int) (res []compositeItem) {
+		start, stop := key.Prefix(ref, n)
+		tree.Range(start, stop, func(c compositeItem) bool {
+			res = append(res, c)
+			return true
+		})
+		return
+	}
+	if res := count(compositeItem{Tenant: "b", When: 99}, 1); len(res) != 8 || res[0].Tenant != "b" || res[0].When != 3 || res[7].When != 0 {
+		t.Fatalf("Unexpected tenant prefix results %v", res)
+	}
+	if res := count(compositeItem{Tenant: "c", When: 2}, 2); len(res) != 2 || res[0].When != 2 || len(res[1].Tags) != 1 {
+		t.Fatalf("Unexpected two part prefix results %v", res)
+	}
+	if res := count(compositeItem{Tenant: "d"}, 1); len(res) != 0 {
+		t.Fatalf("Expected no results, got %v", res)
+	}
+	if res := count(compositeItem{}, 0); len(res) != tree.Len() {
+		t.Fatalf("Empty prefix should match everything")
+	}
+	// KeyParts made from a cmp that returns more than -1 or 1 still bound the prefix.
+	sub := Composite(
+		AscFunc(func(c compositeItem) int { return c.When }, func(a, b int) int { return (a - b) * 10 }),
+		Asc(func(c compositeItem) string { return c.Tenant }),
+	)
+	subTree := sub.New()
+	defer subTree.Release()
+	tree.Walk(func(c compositeItem) bool { subTree.Insert(c); return true })
+	start, stop := sub.Prefix(compositeItem{When: 2}, 1)
+	var res []compositeItem
+	subTree.Range(start, stop, func(c compositeItem) bool { res = append(res, c); return true })
+	if len(res) != 3 || res[0].When != 2 || res[2].When != 2 {
+		t.Fatalf("Unexpected prefix results with a wide cmp %v", res)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected a panic")
+		}
+	}()
+	key.Prefix(compositeItem{}, 4)
+}
+
+func TestMaintainer(t *testing.T) {
+	var mu sync.Mutex
+	tree, _ := newIntTree()
+	defer tree.Release()
+	tree.DeferRebalance()
+	m := NewMaintainer(&mu, time.Millisecond)
+	m.Add("rebalance", func() error {
+		tree.Rebalance()
+		tree.DeferRebalance()
+		return nil
+	})
+	boom := errors.New("boom")
+	m.Add("fail", func() error { return boom })
+	m.RunOnce()
+	st := m.Stats()
+	if len(st) != 2 || st[0].Name != "rebalance" || st[0].Runs != 1 || st[0].Failures != 0 ||
+		st[1].Runs != 1 || st[1].Failures != 1 || st[1].LastErr != boom {
+		t.Fatalf("Unexpected stats %+v", st)
+	}
+	m.Start()
+	m.Start()
+	for i := 0; i < 1000; i++ {
+		mu.Lock()
+		tree.Insert(i)
+		mu.Unlock()
+	}
+	for m.Stats()[0].Runs < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Stop()
+	m.Stop()
+	runs := m.Stats()[0].Runs
+	time.Sleep(5 * time.Millisecond)
+	if m.Stats()[0].Runs != runs {
+		t.Fatalf("Tasks ran after Stop")
+	}
+	m.RunOnce()
+	if err := tree.Validate(); err != nil || tree.HeightRatio() > 1.44 {
+		t.Fatalf("Tree not rebalanced: %v %f", err, tree.HeightRatio())
+	}
+	// A bad interval panics in Start, not in the goroutine Start makes.
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected Start to panic with a zero interval")
+		}
+	}()
+	NewMaintainer(&mu, 0).Start()
+}
+
+func TestWriteGate(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	var seen []WriteInfo
+	tree.SetWriteGate(func(w WriteInfo) error {
+		seen = append(seen, w)
+		if w.Len >= 3 {
+			return ErrBackpressure
+		}
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		err := tree.TryInsert(i)
+		if (i < 3) != (err == nil) || (i >= 3 && !errors.Is(err, ErrBackpressure)) {
+			t.Fatalf("%d: unexpected error %v", i, err)
+		}
+	}
+	if err := tree.InsertNew(7); err != ErrBackpressure {
+		t.Fatalf("Expected InsertNew to be rejected, got %v", err)
+	}
+	if err := tree.InsertNew(1); err != ErrExists {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	tree.MarkDirty(cmp(1))
+	tree.DeferRebalance()
+	tree.TryInsert(8)
+	last := seen[len(seen)-1]
+	if last.Len != 3 || last.Dirty != 1 || !last.Deferred || last.Bytes <= 0 || len(seen) != 7 {
+		t.Fatalf("Unexpected write info %+v (%d calls)", last, len(seen))
+	}
+	// Writes that cannot return an error wait for the gate but are not rejected.
+	tree.Insert(9)
+	if !tree.Has(cmp(9)) || len(seen) != 8 {
+		t.Fatalf("Expected Insert to call the gate and carry on (%d calls)", len(seen))
+	}
+	other := tree.Copy()
+	other.Insert(20)
+	if err := tree.MergeE(other, nil); err != ErrBackpressure || other.Len() != 1 || len(seen) != 9 {
+		t.Fatalf("Expected MergeE to be rejected, got %v", err)
+	}
+	tree.Merge(other, nil)
+	if !tree.Has(cmp(20)) || len(seen) != 10 {
+		t.Fatalf("Expected Merge to call the gate once (%d calls)", len(seen))
+	}
+	o := NewOverlay(tree)
+	o.Push()
+	o.Insert(21)
+	o.CompactStep()
+	if !tree.Has(cmp(21)) || len(seen) != 12 {
+		t.Fatalf("Expected Overlay Insert and CompactStep to call the gate (%d calls)", len(seen))
+	}
+	tree.SetWriteGate(nil)
+	if err := tree.TryInsert(10); err != nil || tree.Len() != 7 || len(seen) != 12 {
+		t.Fatalf("Gate still active")
+	}
+	tree.SetWriteGate(func(w WriteInfo) error {
+		seen = append(seen, w)
+		return ErrBackpressure
+	})
+	h := NewHybrid(tree)
+	h.Insert(22)
+	if last := seen[len(seen)-1]; !h.Has(cmp(22)) || len(seen) != 13 || last.Len != 7 || last.Bytes <= 0 {
+		t.Fatalf("Expected Hybrid Insert to call the gate, got %+v (%d calls)", last, len(seen))
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+	if _, err := tree.Restore(cmp(1)); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound without soft delete, got %v", err)
+	}
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	tree.SetClock(clock)
+	tree.SoftDelete(10 * time.Millisecond)
+	for i := 0; i < 5; i++ {
+		tree.Delete(i)
+	}
+	tree.Delete(4)
+	if tree.Len() != 5 || tree.Recycled() != 5 {
+		t.Fatalf("Expected 5 items and 5 recycled, got %d and %d", tree.Len(), tree.Recycled())
+	}
+	if v, err := tree.Restore(cmp(2)); err != nil || v != 2 || !tree.Has(cmp(2)) || tree.Recycled() != 4 {
+		t.Fatalf("Failed to restore 2: %v", err)
+	}
+	if _, err := tree.Restore(cmp(2)); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	tree.Insert(3)
+	if _, err := tree.Restore(cmp(3)); err != ErrExists || tree.Recycled() != 4 {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	if n := tree.Purge(); n != 0 {
+		t.Fatalf("Purged %d items too early", n)
+	}
+	clock.advance(15 * time.Millisecond)
+	tree.Delete(3)
+	if n := tree.Recycled(); n != 1 {
+		t.Fatalf("Expected Delete to purge old items, have %d recycled", n)
+	}
+	if _, err := tree.Restore(cmp(3)); err != nil {
+		t.Fatalf("Failed to restore 3: %v", err)
+	}
+	// Restored items get the same checks as TryInsert.
+	errOdd := errors.New("odd")
+	tree.SetDomain(func(v int) error {
+		if v%2 != 0 {
+			return errOdd
+		}
+		return nil
+	})
+	tree.Delete(7)
+	if _, err := tree.Restore(cmp(7)); err != errOdd || tree.Has(cmp(7)) || tree.Recycled() != 1 {
+		t.Fatalf("Expected the domain check to keep 7 in the recycle bin, got %v", err)
+	}
+	tree.SetDomain(nil)
+	if _, err := tree.Restore(cmp(7)); err != nil {
+		t.Fatalf("Failed to restore 7: %v", err)
+	}
+	tree.Delete(5)
+	tree.SoftDelete(0)
+	if tree.Recycled() != 0 || tree.Purge() != 0 {
+		t.Fatalf("Expected recycle bin to be emptied")
+	}
+	if err := tree.Validate(); err != nil || tree.Len() != 6 {
+		t.Fatalf("Bad tree: %v %d", err, tree.Len())
+	}
+}
+
+func TestSessionView(t *testing.T) {
+	base, cmp := newIntTree()
+	defer base.Release()
+	model := map[int]bool{}
+	for i := 0; i < 100; i += 2 {
+		base.Insert(i)
+		model[i] = true
+	}
+	s := base.Session()
+	defer s.Release()
+	rs := rand.New(rand.NewSource(1))
+	for i := 0; i < 200; i++ {
+		v := rs.Intn(110)
+		if rs.Intn(2) == 0 {
+			s.Insert(v)
+			model[v] = true
+		} else {
+			_, found := s.Delete(v)
+			if found != model[v] {
+				t.Fatalf("Delete(%d) returned %v", v, found)
+			}
+			delete(model, v)
+		}
+	}
+	var expect, got []int
+	for v := range model {
+		expect = append(expect, v)
+	}
+	sort.Ints(expect)
+	s.Walk(func(v int) bool { got = append(got, v); return true })
+	if !reflect.DeepEqual(expect, got) || s.Len() != len(expect) {
+		t.Fatalf("Expected %v (%d), got %v", expect, s.Len(), got)
+	}
+	for v := 0; v < 110; v++ {
+		if s.Has(cmp(v)) != model[v] {
+			t.Fatalf("Has(%d) is wrong", v)
+		}
+	}
+	got = nil
+	s.Range(Lt(cmp(20)), Gte(cmp(40)), func(v int) bool { got = append(got, v); return len(got) < 5 })
+	var bounded []int
+	for _, v := range expect {
+		if v >= 20 && v < 40 && len(bounded) < 5 {
+			bounded = append(bounded, v)
+		}
+	}
+	if !reflect.DeepEqual(bounded, got) {
+		t.Fatalf("Expected %v, got %v", bounded, got)
+	}
+	if base.Len() != 50 || s.Changes() == 0 {
+		t.Fatalf("Base changed before Commit")
+	}
+	s.Commit()
+	got = nil
+	base.Walk(func(v int) bool { got = append(got, v); return true })
+	if !reflect.DeepEqual(expect, got) || s.Changes() != 0 || s.Len() != base.Len() {
+		t.Fatalf("Commit did not apply changes")
+	}
+	s.Insert(1000)
+	s.Discard()
+	if s.Has(cmp(1000)) || base.Has(cmp(1000)) {
+		t.Fatalf("Discard did not throw away changes")
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	base, cmp := newIntTree()
+	defer base.Release()
+	o := NewOverlay(base)
+	defer o.Release()
+	model := map[int]bool{}
+	rs := rand.New(rand.NewSource(2))
+	check := func() {
+		var expect, got []int
+		for v := range model {
+			expect = append(expect, v)
+		}
+		sort.Ints(expect)
+		o.Walk(func(v int) bool { got = append(got, v); return true })
+		if !reflect.DeepEqual(expect, got) || o.Len() != len(expect) {
+			t.Fatalf("%d layers: expected %v, got %v", o.Layers(), expect, got)
+		}
+		for v := 0; v < 60; v++ {
+			if o.Has(cmp(v)) != model[v] {
+				t.Fatalf("%d layers: Has(%d) is wrong", o.Layers(), v)
+			}
+		}
+	}
+	for layer := 0; layer < 4; layer++ {
+		for i := 0; i < 40; i++ {
+			v := rs.Intn(60)
+			if rs.Intn(3) > 0 {
+				o.Insert(v)
+				model[v] = true
+			} else {
+				if _, found := o.Delete(v); found != model[v] {
+					t.Fatalf("Delete(%d) returned %v", v, found)
+				}
+				delete(model, v)
+			}
+		}
+		check()
+		o.Push()
+	}
+	if o.Layers() != 5 {
+		t.Fatalf("Expected 5 layers, got %d", o.Layers())
+	}
+	saved := map[int]bool{}
+	for v := range model {
+		saved[v] = true
+	}
+	o.Insert(1000)
+	o.Delete(1000)
+	for v := range model {
+		o.Delete(v)
+		delete(model, v)
+	}
+	check()
+	o.Pop()
+	model = saved
+	check()
+	for o.Pop() {
+	}
+	if o.Layers() != 1 || o.Len() != base.Len() {
+		t.Fatalf("Pop should leave only the base")
+	}
+}
+
+func TestOverlayCompact(t *testing.T) {
+	for _, size := range []int{0, 10, 1000} {
+		base, _ := newIntTree()
+		st := &mapStore{}
+		base.SetFlusher(st, false)
+		for i := 0; i < size; i++ {
+			base.Insert(i * 2)
+		}
+		o := NewOverlay(base)
+		model := map[int]bool{}
+		base.Walk(func(v int) bool { model[v] = true; return true })
+		rs := rand.New(rand.NewSource(int64(size)))
+		for layer := 0; layer < 3; layer++ {
+			o.Push()
+			for i := 0; i < 50; i++ {
+				v := rs.Intn(2*size + 20)
+				if rs.Intn(2) == 0 {
+					o.Insert(v)
+					model[v] = true
+				} else {
+					o.Delete(v)
+					delete(model, v)
+				}
+			}
+		}
+		var expect []int
+		o.Walk(func(v int) bool { expect = append(expect, v); return true })
+		if !o.CompactStep() || o.Layers() != 3 {
+			t.Fatalf("CompactStep did not merge a layer")
+		}
+		var progress [][2]int
+		o.Compact(func(merged, total int) { progress = append(progress, [2]int{merged, total}) })
+		if !reflect.DeepEqual(progress, [][2]int{{1, 2}, {2, 2}}) || o.Layers() != 1 || o.CompactStep() {
+			t.Fatalf("Unexpected progress %v", progress)
+		}
+		var got []int
+		base.Walk(func(v int) bool { got = append(got, v); return true })
+		if !reflect.DeepEqual(expect, got) || len(got) != len(model) {
+			t.Fatalf("%d: expected %v, got %v", size, expect, got)
+		}
+		if err := base.Validate(); err != nil {
+			t.Fatalf("%d: %v", size, err)
+		}
+		flushed := map[int]bool{}
+		for _, req := range st.flushed {
+			if flushed[req.item] = !req.deleted; req.deleted {
+				delete(flushed, req.item)
+			}
+		}
+		if !reflect.DeepEqual(flushed, model) {
+			t.Fatalf("%d: Flusher saw %v, expected %v", size, flushed, model)
+		}
+		o.Release()
+		base.Release()
+	}
+}
+
+func TestOverlayCompactChecks(t *testing.T) {
+	base, cmp := newIntTree()
+	defer base.Release()
+	for i := 0; i < 1000; i++ {
+		base.Insert(i)
+	}
+	base.SetKeyRange(Lt(cmp(0)), Gte(cmp(5000)))
+	base.TrackHeavyHitters(4)
+	o := NewOverlay(base)
+	o.Push()
+	// Enough changes to take the single pass path.
+	for i := 0; i < 200; i++ {
+		o.Insert(2000 + i)
+	}
+	o.Insert(6000)
+	func() {
+		defer func() {
+			if recover() != ErrOutOfDomain {
+				t.Fatalf("Expected Compact to panic with ErrOutOfDomain")
+			}
+		}()
+		o.Compact(nil)
+	}()
+	if base.Len() != 1000 {
+		t.Fatalf("Expected the base to be unchanged, got %d items", base.Len())
+	}
+	o.Delete(6000)
+	o.Compact(nil)
+	if base.Len() != 1200 || len(base.TopAccessed(4)) != 4 {
+		t.Fatalf("Expected 1200 items with hits, got %d", base.Len())
+	}
+	if err := base.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestYieldEvery(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	yields := 0
+	tree.YieldEvery(10, func() { yields++ })
+	tree.Walk(func(int) bool { return true })
+	tree.Range(Lt(cmp(10)), Gte(cmp(35)), func(int) bool { return true })
+	tree.BeforeDesc(Gte(cmp(50)), func(v int) bool { return v > 20 })
+	if yields != 10+2+2 {
+		t.Fatalf("Expected 14 yields, got %d", yields)
+	}
+	tree.YieldEvery(1, nil)
+	tree.Walk(func(int) bool { return true })
+	tree.YieldEvery(0, nil)
+	tree.After(nil, func(int) bool { return true })
+	if yields != 14 {
+		t.Fatalf("Expected yielding to be off, got %d", yields)
+	}
+}
+
+func TestRangeWithin(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 1000; i++ {
+		tree.Insert(i)
+	}
+	var got []int
+	start, complete := Lt(cmp(100)), false
+	stop := Gte(cmp(900))
+	calls := 0
+	for !complete {
+		calls++
+		start, complete = tree.RangeWithin(0, start, stop, func(v int) bool {
+			got = append(got, v)
+			return true
+		})
+	}
+	if calls != 800/deadlineCheckEvery+1 || len(got) != 800 || got[0] != 100 || got[799] != 899 {
+		t.Fatalf("Unexpected results from %d calls: %d items", calls, len(got))
+	}
+	for i := range got {
+		if got[i] != i+100 {
+			t.Fatalf("Item %d is %d", i, got[i])
+		}
+	}
+	if resume, complete := tree.RangeWithin(time.Hour, nil, nil, func(v int) bool { return v < 10 }); resume != nil || !complete {
+		t.Fatalf("Expected stopping early to complete")
+	}
+}
+
+func TestResultSet(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	all := tree.Query(nil, nil)
+	if all.Count() != 100 {
+		t.Fatalf("Expected 100 items, got %d", all.Count())
+	}
+	rs := tree.Query(Lt(cmp(10)), Gte(cmp(50)))
+	even := rs.Filter(func(v int) bool { return v%2 == 0 })
+	byThree := even.Filter(func(v int) bool { return v%3 == 0 })
+	byFive := even.Filter(func(v int) bool { return v%5 == 0 })
+	if rs.Count() != 40 || even.Count() != 20 {
+		t.Fatalf("Unexpected counts %d %d", rs.Count(), even.Count())
+	}
+	if got := byThree.ToSlice(); !reflect.DeepEqual(got, []int{12, 18, 24, 30, 36, 42, 48}) {
+		t.Fatalf("Unexpected results %v", got)
+	}
+	if got := byFive.ToSlice(); !reflect.DeepEqual(got, []int{10, 20, 30, 40}) {
+		t.Fatalf("Filters leaked between result sets: %v", got)
+	}
+	if v, found := byThree.First(); !found || v != 12 {
+		t.Fatalf("Unexpected First %d", v)
+	}
+	if v, found := byThree.Last(); !found || v != 48 {
+		t.Fatalf("Unexpected Last %d", v)
+	}
+	if _, found := byThree.Filter(func(v int) bool { return v > 100 }).First(); found {
+		t.Fatalf("Expected empty result set")
+	}
+	tree.Delete(12)
+	seen := 0
+	byThree.Each(func(v int) bool { seen++; return v < 30 })
+	if seen != 3 {
+		t.Fatalf("Expected Each to stop after 3 items, saw %d", seen)
+	}
+}
+
+func TestResultSetOrderBy(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	byLastDigit := func(a, b int) bool { return a%10 < b%10 }
+	index := tree.SortedClone(byLastDigit)
+	defer index.Release()
+	expect := func(lo, hi int) (res []int) {
+		for d := 0; d < 10; d++ {
+			for v := lo; v < hi; v++ {
+				if v%10 == d && v%3 == 0 {
+					res = append(res, v)
+				}
+			}
+		}
+		return
+	}
+	div3 := func(v int) bool { return v%3 == 0 }
+	// A small range gets sorted, and a large one is picked out of the index.
+	small := tree.Query(Lt(cmp(10)), Gte(cmp(20))).Filter(div3).OrderBy(byLastDigit, index)
+	large := tree.Query(Lt(cmp(5)), nil).Filter(div3).OrderBy(byLastDigit, index)
+	unindexed := tree.Query(Lt(cmp(5)), nil).Filter(div3).OrderBy(byLastDigit, nil)
+	if !small.sorted || large.sorted || !unindexed.sorted {
+		t.Fatalf("OrderBy picked the wrong strategy")
+	}
+	for _, c := range []struct {
+		rs     *ResultSet[int]
+		expect []int
+	}{
+		{small, expect(10, 20)},
+		{large, expect(5, 100)},
+		{unindexed, expect(5, 100)},
+	} {
+		if got := c.rs.ToSlice(); !reflect.DeepEqual(got, c.expect) || c.rs.Count() != len(c.expect) {
+			t.Fatalf("Expected %v, got %v", c.expect, got)
+		}
+		if first, _ := c.rs.First(); first != c.expect[0] {
+			t.Fatalf("Unexpected First %d", first)
+		}
+		if last, _ := c.rs.Last(); last != c.expect[len(c.expect)-1] {
+			t.Fatalf("Unexpected Last %d", last)
+		}
+	}
+	if got := small.Filter(func(v int) bool { return v > 12 }).ToSlice(); !reflect.DeepEqual(got, []int{15, 18}) {
+		t.Fatalf("Unexpected filtered sorted results %v", got)
+	}
+}
+
+func TestDepthGuard(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 1000; i++ {
+		tree.Insert(i)
+	}
+	var log anomalyLog
+	tree.SetLogger(&log)
+	tree.SetDepthGuard(0, true)
+	for i := 0; i < 1000; i++ {
+		tree.Get(cmp(i))
+		tree.Delete(i)
+		tree.Insert(i)
+	}
+	// Build a degenerate chain like a broken comparator could.
+	chain := tree.Copy()
+	defer chain.Release()
+	var last *node[int]
+	for i := 0; i < 64; i++ {
+		n := chain.newNode(i)
+		if last == nil {
+			chain.root = n
+		} else {
+			last.r, n.p = n, last
+		}
+		last = n
+	}
+	chain.SetLogger(&log)
+	chain.SetDepthGuard(2, false)
+	if _, found := chain.Get(cmp(63)); !found || len(log) != 1 || log[0].Kind != AnomalyTooDeep {
+		t.Fatalf("Expected one anomaly, got %v", log)
+	}
+	chain.Has(cmp(10))
+	if len(log) != 1 {
+		t.Fatalf("Shallow lookup should not trip the guard")
+	}
+	chain.SetDepthGuard(-1, true)
+	chain.Fetch(63)
+	chain.SetDepthGuard(0, true)
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected a panic")
+		}
+	}()
+	chain.Fetch(63)
+}
+
+func TestVersion(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	last := tree.Version()
+	changed := func(what string, expect bool) {
+		v := tree.Version()
+		if (v != last) != expect {
+			t.Fatalf("%s: expected changed to be %v", what, expect)
+		}
+		last = v
+	}
+	tree.Insert(1)
+	changed("insert", true)
+	tree.Insert(1)
+	changed("replace", true)
+	tree.Get(cmp(1))
+	tree.Walk(func(int) bool { return true })
+	changed("read", false)
+	tree.Delete(2)
+	changed("delete missing", false)
+	tree.Delete(1)
+	changed("delete", true)
+	tree.Insert(3)
+	tree.ExtractRange(nil, nil).Release()
+	changed("extract", true)
+	tree.Reverse()
+	changed("reverse", true)
+}
+
+func TestComparator(t *testing.T) {
+	tree := New(func(a, b int) bool { return a < b }).SetComparator("int")
+	defer tree.Release()
+	for _, i := range []int{3, 1, 2} {
+		tree.Insert(i)
+	}
+	lt := tree.Less()
+	if !lt(1, 2) || lt(2, 1) {
+		t.Fatalf("Less returned the wrong LessThan")
+	}
+	clone := tree.Clone()
+	defer clone.Release()
+	if tree.Comparator() != "int" || clone.Comparator() != "int" {
+		t.Fatalf("Expected clone to keep the comparator name")
+	}
+	clone.Reverse()
+	if clone.Comparator() != "-int" || !clone.Less()(2, 1) {
+		t.Fatalf("Expected reversed comparator, got %q", clone.Comparator())
+	}
+	clone.Reverse()
+	if clone.Comparator() != "int" {
+		t.Fatalf("Expected double reverse to restore the name, got %q", clone.Comparator())
+	}
+	sorted := tree.SortBy(func(a, b int) bool { return a%2 < b%2 })
+	defer sorted.Release()
+	if sorted.Comparator() != "" || NewString().Comparator() != "" || NewBytes().Comparator() != "" {
+		t.Fatalf("Unexpected comparator names")
+	}
+	f := NewForest[int]()
+	f.Register("asc", func(a, b int) bool { return a < b })
+	if f.New("asc").Comparator() != "" {
+		t.Fatalf("Forest trees should not be named unless SetComparator is called")
+	}
+	f.Release()
+}
+
+func TestCheckCompatible(t *testing.T) {
+	a, _ := newIntTree()
+	defer a.Release()
+	b, _ := newIntTree()
+	defer b.Release()
+	for i := 0; i < 20; i++ {
+		a.Insert(i)
+		b.Insert(i * 2)
+	}
+	if err := a.CheckCompatible(b); err != nil {
+		t.Fatalf("Expected compatible trees, got %v", err)
+	}
+	rev := New(func(x, y int) bool { return x > y })
+	defer rev.Release()
+	for i := 0; i < 20; i++ {
+		rev.Insert(i)
+	}
+	if err := a.CheckCompatible(rev); !errors.Is(err, ErrIncompatibleOrdering) {
+		t.Fatalf("Expected ErrIncompatibleOrdering, got %v", err)
+	}
+	if _, err := a.UnionE(rev, nil); !errors.Is(err, ErrIncompatibleOrdering) {
+		t.Fatalf("Expected UnionE to fail, got %v", err)
+	}
+	if _, err := a.ExceptE(rev); !errors.Is(err, ErrIncompatibleOrdering) {
+		t.Fatalf("Expected ExceptE to fail, got %v", err)
+	}
+	if _, _, err := Merge3E(a, b, rev, nil); !errors.Is(err, ErrIncompatibleOrdering) {
+		t.Fatalf("Expected Merge3E to fail, got %v", err)
+	}
+	named := New(a.Less()).SetComparator("mod")
+	defer named.Release()
+	if err := named.CheckCompatible(a.Clone().SetComparator("int")); err != ErrIncompatibleOrdering {
+		t.Fatalf("Expected different names to be incompatible, got %v", err)
+	}
+	f := NewForest[string]()
+	defer f.Release()
+	f.RegisterCmp("asc", strings.Compare)
+	var zero Tree[string]
+	for _, s := range []string{"b", "a", "c"} {
+		zero.Insert(s)
+	}
+	if err := f.New("asc").CheckCompatible(NewString()); err != nil {
+		t.Fatalf("Expected a Forest tree to be compatible with NewString, got %v", err)
+	}
+	if err := NewString().CheckCompatible(&zero); err != nil {
+		t.Fatalf("Expected a zero Tree to be compatible with NewString, got %v", err)
+	}
+	// The methods without an E do not check, so they must not panic.
+	a.ContainsAny(rev)
+	a.Union(rev, nil).Release()
+	if n := testing.AllocsPerRun(10, func() { a.CheckCompatible(b) }); n != 0 {
+		t.Fatalf("Expected CheckCompatible not to allocate, got %v allocations", n)
+	}
+}
+
+func TestZeroTree(t *testing.T) {
+	var ints Tree[int]
+	for _, i := range []int{3, 1, 2} {
+		ints.Insert(i)
+	}
+	if v, _ := ints.Min(); v != 1 || ints.Len() != 3 {
+		t.Fatalf("Zero Tree[int] should be ascending")
+	}
+	if err := ints.InsertNew(2); err != ErrExists {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	var fresh Tree[int]
+	if _, err := fresh.GetE(CmpFunc(1, func(a, b int) int { return a - b })); err != ErrNotFound {
+		t.Fatalf("Expected ErrNotFound from a zero Tree, got %v", err)
+	}
+	var desc Tree[int]
+	desc.Reverse()
+	desc.Insert(1)
+	desc.Insert(2)
+	if v, _ := desc.Min(); v != 2 {
+		t.Fatalf("Reversed zero Tree should be descending, got %d first", v)
+	}
+	ints.Release()
+	if err := ints.InsertNew(4); err != ErrReleased {
+		t.Fatalf("Expected ErrReleased, got %v", err)
+	}
+	if _, err := ints.GetE(CmpFunc(1, func(a, b int) int { return a - b })); err != ErrReleased {
+		t.Fatalf("Expected ErrReleased, got %v", err)
+	}
+	var strs Tree[string]
+	strs.Insert("b")
+	strs.Insert("a")
+	if v, _ := strs.Min(); v != "a" || strs.Comparator() != "" {
+		t.Fatalf("Zero Tree[string] should be ordered like NewString")
+	}
+	type kv struct{ k, v int }
+	type holder struct{ Tree[kv] }
+	var h holder
+	if err := h.TryInsert(kv{1, 1}); err != ErrNoOrdering {
+		t.Fatalf("Expected ErrNoOrdering, got %v", err)
+	}
+	h.SetLess(func(a, b kv) bool { return a.k < b.k })
+	h.Insert(kv{2, 2})
+	h.Insert(kv{1, 1})
+	if v, _ := h.Min(); v.k != 1 {
+		t.Fatalf("SetLess ordering not used")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("Expected SetLess on a non-empty Tree to panic")
+			}
+		}()
+		h.SetLess(func(a, b kv) bool { return a.k > b.k })
+	}()
+	var bad Tree[kv]
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("Expected Insert without an ordering to panic")
+		}
+	}()
+	bad.Insert(kv{})
+}
+
+type version [2]int
+
+func (v version) Less(o version) bool {
+	return v[0] < o[0] || (v[0] == o[0] && v[1] < o[1])
+}
+
+func (v version) Compare(o version) int {
+	switch {
+	case v.Less(o):
+		return Less
+	case o.Less(v):
+		return Greater
+	}
+	return Equal
+}
+
+func TestSelfOrdered(t *testing.T) {
+	items := []version{{1, 2}, {0, 9}, {1, 0}}
+	want := []version{{0, 9}, {1, 0}, {1, 2}}
+	for _, tree := range []*Tree[version]{NewSelfOrdered[version](), NewComparable[version]()} {
+		for _, v := range items {
+			tree.Insert(v)
+		}
+		if got, _, _ := tree.Page(nil, 10); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+		if v, ok := tree.Get(tree.Cmp(version{1, 0})); !ok || v != (version{1, 0}) {
+			t.Fatalf("Get failed")
+		}
+		tree.Release()
+	}
+	if NewComparable[version]().cmp == nil {
+		t.Fatalf("NewComparable should use the three-way comparison")
+	}
+}
+
+func TestIteratorPull(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+	}
+	next, stop := tree.Iterator(Lt(cmp(3)), Gte(cmp(6))).Pull()
+	for want := 3; want < 6; want++ {
+		if v, ok := next(); !ok || v != want {
+			t.Fatalf("Expected %d, got %d %v", want, v, ok)
+		}
+	}
+	if _, ok := next(); ok {
+		t.Fatalf("Expected next to be finished")
+	}
+	stop()
+	var got []int
+	tree.Iterator(nil, nil).Seq()(func(v int) bool {
+		got = append(got, v)
+		return v < 4
+	})
+	if !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("Seq should stop when yield returns false, got %v", got)
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	tree.SetKeyRange(Lt(cmp(10)), Gte(cmp(20)))
+	for _, i := range []int{10, 15, 19} {
+		if err := tree.TryInsert(i); err != nil {
+			t.Fatalf("Expected %d to be accepted, got %v", i, err)
+		}
+	}
+	for _, i := range []int{9, 20} {
+		if err := tree.TryInsert(i); err != ErrOutOfDomain {
+			t.Fatalf("Expected ErrOutOfDomain for %d, got %v", i, err)
+		}
+		if err := tree.InDomain(i); err != ErrOutOfDomain {
+			t.Fatalf("Expected InDomain to reject %d", i)
+		}
+	}
+	if err := tree.InsertNew(25); err != ErrOutOfDomain || tree.Len() != 3 {
+		t.Fatalf("InsertNew should reject out of range items")
+	}
+	bad := errors.New("odd")
+	tree.SetDomain(func(i int) error {
+		if i%2 == 1 {
+			return bad
+		}
+		return nil
+	})
+	func() {
+		defer func() {
+			if recover() != bad {
+				t.Fatalf("Expected Insert to panic with the domain error")
+			}
+		}()
+		tree.Insert(3)
+	}()
+	tree.SetDomain(nil)
+	tree.Insert(3)
+	if tree.Len() != 4 {
+		t.Fatalf("Expected the domain check to be removed")
+	}
+}
+
+func TestAt(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	rs := rand.New(rand.NewSource(1))
+	check := func(msg string) {
+		if err := tree.Validate(); err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+		i := 0
+		tree.Walk(func(v int) bool {
+			if got, ok := tree.At(i); !ok || got != v {
+				t.Fatalf("%s: At(%d) = %d, expected %d", msg, i, got, v)
+			}
+			i++
+			return true
+		})
+		if _, ok := tree.At(tree.Len()); ok {
+			t.Fatalf("%s: At past the end should fail", msg)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		tree.Insert(rs.Intn(500))
+		if i%3 == 0 {
+			tree.Delete(rs.Intn(500))
+		}
+	}
+	check("insert and delete")
+	tree.DeferRebalance()
+	for i := 0; i < 200; i++ {
+		tree.Insert(500 + i)
+	}
+	check("deferred")
+	tree.Rebalance()
+	check("rebalanced")
+	tree.ExtractRange(Lt(cmp(100)), Gte(cmp(300))).Release()
+	check("extract")
+	clone := tree.Clone()
+	defer clone.Release()
+	want, _ := tree.At(5)
+	if got, _ := clone.At(5); got != want || clone.Validate() != nil {
+		t.Fatalf("Clone should keep subtree sizes")
+	}
+	if _, ok := tree.At(-1); ok {
+		t.Fatalf("At(-1) should fail")
+	}
+}
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestClock(t *testing.T) {
+	type event struct {
+		at time.Time
+		id int
+	}
+	clock := &fakeClock{now: time.Date(2022, 7, 16, 0, 0, 0, 0, time.UTC)}
+	ts := NewTimeSeries[event](func(a, b event) bool { return a.id < b.id },
+		func(e event) time.Time { return e.at }, time.Hour)
+	defer ts.Release()
+	ts.SetClock(clock)
+	for i := 0; i < 10; i++ {
+		ts.Insert(event{at: clock.Now(), id: i})
+		clock.advance(time.Hour)
+	}
+	if dropped := ts.ExpireOlderThan(5 * time.Hour); dropped != 5 || ts.Len() != 5 {
+		t.Fatalf("Expected 5 items to expire, not %d", dropped)
+	}
+	var mu sync.Mutex
+	m := NewMaintainer(&mu, time.Hour)
+	m.SetClock(clock)
+	m.Add("slow", func() error {
+		clock.advance(time.Minute)
+		return nil
+	})
+	m.RunOnce()
+	if busy := m.Stats()[0].Busy; busy != time.Minute {
+		t.Fatalf("Expected the task to be busy for a minute, got %v", busy)
+	}
+	tree, _ := newIntTree()
+	defer tree.Release()
+	tree.SetClock(clock)
+	for i := 0; i < 200; i++ {
+		tree.Insert(i)
+	}
+	resume, complete := tree.RangeWithin(time.Second, nil, nil, func(int) bool {
+		clock.advance(time.Second)
+		return true
+	})
+	if complete || resume == nil || tree.Copy().now() != clock.Now() {
+		t.Fatalf("Expected RangeWithin to run out of time on the fake clock")
+	}
+}
+
+func TestRank(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(i)
+	}
+	for i := -1; i <= 100; i++ {
+		rank, found := tree.Rank(cmp(i))
+		if want := (i + 1) / 2; rank != want || found != (i >= 0 && i%2 == 0 && i < 100) {
+			t.Fatalf("Rank(%d) = %d %v, expected %d", i, rank, found, want)
+		}
+		if found {
+			if v, _ := tree.At(rank); v != i {
+				t.Fatalf("At(Rank(%d)) = %d", i, v)
+			}
+		}
+	}
+	for _, r := range []struct{ start, stop, want int }{{10, 20, 5}, {11, 21, 5}, {-5, 5, 3}, {90, 200, 5}, {20, 10, 0}} {
+		if n := tree.Query(Lt(cmp(r.start)), Gte(cmp(r.stop))).Count(); n != r.want {
+			t.Fatalf("Count of %d to %d is %d, expected %d", r.start, r.stop, n, r.want)
+		}
+	}
+}
+
+func TestDeleteRange(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 1000; i++ {
+		tree.Insert(i)
+	}
+	if n := tree.DeleteRange(Lt(cmp(10)), Gte(cmp(15))); n != 5 || tree.Len() != 995 || tree.Has(cmp(12)) {
+		t.Fatalf("Expected 5 items deleted, got %d", n)
+	}
+	if n := tree.DeleteRange(Lt(cmp(100)), Gte(cmp(900))); n != 800 || tree.Len() != 195 {
+		t.Fatalf("Expected 800 items deleted, got %d", n)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tree.DeleteRange(Lt(cmp(100)), Gte(cmp(900))); n != 0 {
+		t.Fatalf("Expected nothing left to delete, got %d", n)
+	}
+	tree.SoftDelete(time.Hour)
+	if n := tree.DeleteRange(nil, Gte(cmp(5))); n != 5 || tree.Recycled() != 5 {
+		t.Fatalf("Expected deleted items to be recycled, got %d and %d", n, tree.Recycled())
+	}
+	if _, err := tree.Restore(cmp(3)); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	rs := rand.New(rand.NewSource(3))
+	for _, sz := range []int{1, 2, 10, 1000} {
+		for round := 0; round < 20; round++ {
+			tree, cmp := newIntTree()
+			for _, i := range rs.Perm(sz) {
+				tree.Insert(i)
+			}
+			lo, hi := rs.Intn(sz+1), rs.Intn(sz+1)
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if n := tree.DeleteRange(Lt(cmp(lo)), Gte(cmp(hi))); n != hi-lo || tree.Len() != sz-n {
+				t.Fatalf("%d: deleting [%d,%d) removed %d items", sz, lo, hi, n)
+			}
+			if err := tree.Validate(); err != nil {
+				t.Fatalf("%d: deleting [%d,%d): %v", sz, lo, hi, err)
+			}
+			tree.root.balanced(t)
+			tree.Release()
+		}
+	}
+}
+
+func TestPressureWatcher(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	tree.SoftDelete(time.Hour)
+	tree.TrackAccess(1)
+	for i := 0; i < 10; i++ {
+		tree.Insert(i)
+		tree.Get(cmp(i))
+	}
+	for i := 0; i < 4; i++ {
+		tree.Delete(i)
+	}
+	heap := uint64(100)
+	w := NewPressureWatcher(200)
+	w.HeapSize = func() uint64 { return heap }
+	var calls []string
+	w.OnPressure("tree", func() {
+		calls = append(calls, "tree")
+		if n := tree.Shed(); n != 4 {
+			t.Errorf("Expected 4 recycled items to be dropped, got %d", n)
+		}
+	})
+	w.OnPressure("other", func() { calls = append(calls, "other") })
+	w.Check()
+	if w.Reliefs() != 0 || len(calls) != 0 {
+		t.Fatalf("Check should not relieve pressure under the limit")
+	}
+	heap = 300
+	if err := w.Check(); err != nil || w.Reliefs() != 1 || !reflect.DeepEqual(calls, []string{"tree", "other"}) {
+		t.Fatalf("Expected handlers to be called in order, got %v", calls)
+	}
+	if tree.Recycled() != 0 || len(tree.HotRanges(1)) != 0 || tree.Len() != 6 {
+		t.Fatalf("Shed should drop recycled items and access counts but keep items")
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	for i := 0; i < 100; i++ {
+		tree.Insert(i)
+	}
+	if n := tree.DeleteIf(func(i int) bool { return i%10 == 0 }); n != 10 || tree.Len() != 90 || tree.Has(cmp(50)) {
+		t.Fatalf("Expected 10 items deleted, got %d", n)
+	}
+	if n := tree.DeleteIf(func(i int) bool { return i%2 == 1 }); n != 50 || tree.Len() != 40 {
+		t.Fatalf("Expected 50 items deleted, got %d", n)
+	}
+	if err := tree.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tree.DeleteIf(func(int) bool { return false }); n != 0 {
+		t.Fatalf("Expected nothing deleted, got %d", n)
+	}
+}
+
+func TestTopAccessed(t *testing.T) {
+	tree, cmp := newIntTree()
+	defer tree.Release()
+	if tree.TopAccessed(1) != nil {
+		t.Fatalf("Expected nil without tracking")
+	}
+	for i := 0; i < 1000; i++ {
+		tree.Insert(i)
+	}
+	tree.TrackHeavyHitters(10)
+	rs := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		switch {
+		case i%4 == 0:
+			tree.Get(cmp(7))
+		case i%8 == 1:
+			tree.Fetch(500)
+		case i%8 == 3:
+			tree.Insert(42)
+		default:
+			tree.Get(cmp(rs.Intn(1000)))
+		}
+	}
+	counters := tree.hitters.counters
+	if !sort.SliceIsSorted(counters, func(i, j int) bool { return counters[i].Item < counters[j].Item }) {
+		t.Fatalf("Counters are out of order: %v", counters)
+	}
+	top := tree.TopAccessed(3)
+	if len(top) != 3 || top[0].Item != 7 || top[1].Item+top[2].Item != 542 {
+		t.Fatalf("Unexpected heavy hitters %v", top)
+	}
+	if top[0].Count < 2500 || top[0].Count-top[0].Error > 2600 {
+		t.Fatalf("Count bounds for 7 are wrong: %v", top[0])
+	}
+	// Reversing the Tree keeps the counters in the Tree's order.
+	tree.Reverse()
+	counters = tree.hitters.counters
+	if !sort.SliceIsSorted(counters, func(i, j int) bool { return counters[i].Item > counters[j].Item }) {
+		t.Fatalf("Counters are out of order after Reverse: %v", counters)
+	}
+	tree.Fetch(7)
+	if again := tree.TopAccessed(1); len(again) != 1 || again[0].Item != 7 || again[0].Count != top[0].Count+1 {
+		t.Fatalf("Expected 7 to keep its counter after Reverse, got %v", again)
+	}
+	tree.TrackHeavyHitters(0)
+	if tree.TopAccessed(3) != nil {
+		t.Fatalf("Expected tracking to stop")
+	}
+}
+
+func TestTreeMerge(t *testing.T) {
+	type kv struct{ k, v int }
+	lt := func(a, b kv) bool { return a.k < b.k }
+	sum := func(a, b kv) kv { return kv{a.k, a.v + b.v} }
+	for _, sz := range []int{10, 1000} {
+		a, b := New(lt), New(lt)
+		for i := 0; i < 2000; i += 2 {
+			a.Insert(kv{i, 1})
+		}
+		for i := 0; i < sz; i++ {
+			b.Insert(kv{i * 3, 10})
+		}
+		want := a.Union(b, sum)
+		a.Merge(b, sum)
+		if b.Len() != 0 || a.Len() != want.Len() {
+			t.Fatalf("Expected %d items after merging %d, got %d and %d left", want.Len(), sz, a.Len(), b.Len())
+		}
+		if err := a.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(a.Query(nil, nil).ToSlice(), want.Query(nil, nil).ToSlice()) {
+			t.Fatalf("Merge and Union disagree merging %d items", sz)
+		}
+		b.Insert(kv{0, 100})
+		a.Merge(b, nil)
+		if v, _ := a.Min(); v.v != 100 {
+			t.Fatalf("Expected a nil onConflict to replace items, got %v", v)
+		}
+		a.Release()
+		b.Release()
+		want.Release()
+	}
+	for _, sz := range []int{5, 1000} {
+		c, cmp := newIntTree()
+		d, _ := newIntTree()
+		for i := 0; i < sz; i++ {
+			c.Insert(i % 10)
+			d.Insert(1000 + i)
+		}
+		c.SetKeyRange(Lt(cmp(0)), Gte(cmp(10)))
+		before := c.Len()
+		if err := c.MergeE(d, nil); err != ErrOutOfDomain || c.Len() != before || d.Len() != sz {
+			t.Fatalf("Expected a failed MergeE to leave both Trees alone")
+		}
+		func() {
+			defer func() {
+				if recover() != ErrOutOfDomain {
+					t.Fatalf("Expected Merge to panic with ErrOutOfDomain")
+				}
+			}()
+			c.Merge(d, nil)
+		}()
+		if v, _ := c.Max(); v >= 10 || c.Len() != before {
+			t.Fatalf("Expected no out of domain items in c, got %d", v)
+		}
+		c.Release()
+		d.Release()
+	}
+}
+
+func TestSplit(t *testing.T) {
+	rs := rand.New(rand.NewSource(1))
+	for _, sz := range []int{0, 1, 2, 10, 1000} {
+		for _, pivot := range []int{-1, 0, sz / 3, sz / 2, sz - 1, sz, sz + 5} {
+			tree, cmp := newIntTree()
+			for _, i := range rs.Perm(sz) {
+				tree.Insert(i * 2)
+			}
+			left, right := tree.Split(cmp(pivot))
+			if tree.Len() != 0 || tree.Validate() != nil {
+				t.Fatalf("Expected Split to leave the tree empty")
+			}
+			for _, half := range []*Tree[int]{left, right} {
+				if err := half.Validate(); err != nil {
+					t.Fatalf("Split %d at %d: %v", sz, pivot, err)
+				}
+			}
+			want := (pivot + 1) / 2
+			if want < 0 {
+				want = 0
+			}
+			if want > sz {
+				want = sz
+			}
+			if left.Len() != want || right.Len() != sz-want {
+				t.Fatalf("Split %d at %d gave %d and %d items", sz, pivot, left.Len(), right.Len())
+			}
+			if v, ok := left.Max(); ok && v >= pivot {
+				t.Fatalf("Split %d at %d left %d on the left", sz, pivot, v)
+			}
+			if v, ok := right.Min(); ok && v < pivot {
+				t.Fatalf("Split %d at %d left %d on the right", sz, pivot, v)
+			}
+			if v, ok := right.At(0); ok && v != want*2 {
+				t.Fatalf("Split sizes are wrong")
+			}
+			left.Release()
+			right.Release()
+			tree.Release()
+		}
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, sz := range []int{0, 1, 2, 3, 7, 8, 100} {
+		tree, cmp := newIntTree()
+		for i := 0; i < sz; i++ {
+			tree.Insert(i * 2)
+		}
+		c := tree.Compile()
+		tree.Insert(-10)
+		if c.Len() != sz {
+			t.Fatalf("Expected %d items, got %d", sz, c.Len())
+		}
+		var got []int
+		c.Walk(func(v int) bool { got = append(got, v); return true })
+		for i, v := range got {
+			if v != i*2 {
+				t.Fatalf("Compiled %d items out of order: %v", sz, got)
+			}
+		}
+		if len(got) != sz {
+			t.Fatalf("Walk visited %d of %d items", len(got), sz)
+		}
+		for i := -1; i <= sz*2; i++ {
+			_, found := c.Get(cmp(i))
+			_, fetched := c.Fetch(i)
+			if want := i >= 0 && i%2 == 0 && i < sz*2; found != want || fetched != want || c.Has(cmp(i)) != want {
+				t.Fatalf("Get(%d) on %d items returned %v", i, sz, found)
+			}
+		}
+		if v, ok := c.Min(); ok != (sz > 0) || v != 0 {
+			t.Fatalf("Min returned %d %v", v, ok)
+		}
+		if v, ok := c.Max(); ok != (sz > 0) || (ok && v != (sz-1)*2) {
+			t.Fatalf("Max returned %d %v", v, ok)
+		}
+		for lo := -1; lo <= sz*2; lo += 3 {
+			for hi := lo; hi <= sz*2+1; hi += 5 {
+				var want, asc, desc []int
+				tree.Range(Lt(cmp(lo)), Gte(cmp(hi)), func(v int) bool { want = append(want, v); return true })
+				c.Range(Lt(cmp(lo)), Gte(cmp(hi)), func(v int) bool { asc = append(asc, v); return true })
+				c.RangeDesc(Lt(cmp(lo)), Gte(cmp(hi)), func(v int) bool { desc = append([]int{v}, desc...); return true })
+				if !reflect.DeepEqual(want, asc) || !reflect.DeepEqual(want, desc) {
+					t.Fatalf("Range %d to %d on %d items: expected %v, got %v and %v", lo, hi, sz, want, asc, desc)
+				}
+			}
+		}
+		tree.Release()
+	}
+}
+
+func BenchmarkCompiledFetch(b *testing.B) {
+	for _, sz := range []int{1 << 16, 1 << 22} {
+		tree, _ := newIntTree()
+		for j := 0; j < sz; j++ {
+			tree.Insert(j)
+		}
+		c := tree.Compile()
+		items := rand.Perm(sz)
+		b.Run(fmt.Sprintf("tree size %d", sz), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.Fetch(items[i%sz])
+			}
+		})
+		b.Run(fmt.Sprintf("compiled size %d", sz), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c.Fetch(items[i%sz])
+			}
+		})
+		tree.Release()
+	}
+}
+
+func TestHybrid(t *testing.T) {
+	tree, cmp := newIntTree()
+	for i := 0; i < 100; i += 2 {
+		tree.Insert(i)
+	}
+	h := NewHybrid(tree)
+	defer h.Release()
+	tree.Release()
+	model := map[int]bool{}
+	for i := 0; i < 100; i += 2 {
+		model[i] = true
+	}
+	rs := rand.New(rand.NewSource(1))
+	check := func(msg string) {
+		var want, got []int
+		for i := -1; i <= 101; i++ {
+			if model[i] {
+				want = append(want, i)
+			}
+			if h.Has(cmp(i)) != model[i] {
+				t.Fatalf("%s: Has(%d) should be %v", msg, i, model[i])
+			}
+		}
+		h.Walk(func(v int) bool { got = append(got, v); return true })
+		if !reflect.DeepEqual(want, got) || h.Len() != len(want) {
+			t.Fatalf("%s: expected %v, got %v with Len %d", msg, want, got, h.Len())
+		}
+		var part []int
+		h.Range(Lt(cmp(20)), Gte(cmp(40)), func(v int) bool {
+			part = append(part, v)
+			return len(part) < 5
+		})
+		for _, v := range part {
+			if v < 20 || v >= 40 || !model[v] {
+				t.Fatalf("%s: Range returned %v", msg, part)
+			}
+		}
+	}
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 50; i++ {
+			v := rs.Intn(100)
+			if rs.Intn(2) == 0 {
+				h.Insert(v)
+				model[v] = true
+			} else if _, found := h.Delete(v); found != model[v] {
+				t.Fatalf("Delete(%d) returned %v", v, found)
+			} else {
+				delete(model, v)
+			}
+		}
+		check("before recompile")
+		if h.DeltaLen() == 0 {
+			t.Fatalf("Expected changes waiting to be compiled")
+		}
+		if err := h.Recompile(); err != nil || h.DeltaLen() != 0 || h.Base().Len() != h.Len() {
+			t.Fatalf("Recompile did not fold in the changes")
+		}
+		check("after recompile")
+	}
+}
+
+func TestSmallTree(t *testing.T) {
+	small, cmp := newIntTree()
+	// Tracking access keeps big in nodes, to check small against.
+	big, _ := newIntTree()
+	big.TrackAccess(1 << 30)
+	rs := rand.New(rand.NewSource(7))
+	walk := func(tree *Tree[int], start, stop Test[int]) (res []int) {
+		tree.Range(start, stop, func(v int) bool {
+			res = append(res, v)
+			return true
+		})
+		return
+	}
+	steps := func(tree *Tree[int], start, stop Test[int], moves []bool) (res []int) {
+		iter := tree.Iterator(start, stop)
+		for _, next := range moves {
+			ok := false
+			if next {
+				ok = iter.Next()
+			} else {
+				ok = iter.Prev()
+			}
+			if !ok {
+				return append(res, -1)
+			}
+			res = append(res, iter.Item())
+		}
+		return
+	}
+	for i := 0; i < 2000; i++ {
+		v := rs.Intn(24)
+		if rs.Intn(3) == 0 {
+			a, aok := small.Delete(v)
+			b, bok := big.Delete(v)
+			if a != b || aok != bok {
+				t.Fatalf("Delete(%d): %d %v != %d %v", v, a, aok, b, bok)
+			}
+		} else if small.Len() < smallMax {
+			small.Insert(v)
+			big.Insert(v)
+		}
+		if big.small != nil || small.root != nil || len(small.small) != small.Len() || small.Len() != big.Len() {
+			t.Fatalf("Small tree has %d items in %d slots and a root of %v", small.Len(), len(small.small), small.root)
+		}
+		if err := small.Validate(); err != nil {
+			t.Fatalf("Validate: %v", err)
+		}
+		lo, hi := rs.Intn(24), rs.Intn(24)
+		start, stop := Lt(cmp(lo)), Gte(cmp(hi))
+		if a, b := walk(small, start, stop), walk(big, start, stop); !reflect.DeepEqual(a, b) {
+			t.Fatalf("Range(%d, %d): %v != %v", lo, hi, a, b)
+		}
+		moves := make([]bool, rs.Intn(12))
+		for j := range moves {
+			moves[j] = rs.Intn(3) != 0
+		}
+		if a, b := steps(small, start, stop, moves), steps(big, start, stop, moves); !reflect.DeepEqual(a, b) {
+			t.Fatalf("Iterator(%d, %d) %v: %v != %v", lo, hi, moves, a, b)
+		}
+		for _, get := range []func(*Tree[int]) (int, bool){
+			func(tree *Tree[int]) (int, bool) { return tree.Get(cmp(v)) },
+			func(tree *Tree[int]) (int, bool) { return tree.Fetch(v) },
+			func(tree *Tree[int]) (int, bool) { return tree.Min() },
+			func(tree *Tree[int]) (int, bool) { return tree.Max() },
+			func(tree *Tree[int]) (int, bool) { return tree.At(v % 8) },
+		} {
+			a, aok := get(small)
+			b, bok := get(big)
+			if a != b || aok != bok {
+				t.Fatalf("Lookup of %d: %d %v != %d %v", v, a, aok, b, bok)
+			}
+		}
+	}
+	// The Tree moves into nodes once it outgrows the slice.
+	for i := 0; small.Len() <= smallMax; i++ {
+		small.Insert(i)
+	}
+	if small.small != nil || small.root == nil {
+		t.Fatalf("Expected %d items to be held in nodes", small.Len())
+	}
+	if err := small.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	// Emptying it lets the next items go back into a slice.
+	small.DeleteRange(nil, nil)
+	small.Insert(1)
+	if small.root != nil || len(small.small) != 1 {
+		t.Fatalf("Expected an emptied Tree to use a slice again")
+	}
+	// Operations that rearrange nodes work on a small Tree too.
+	small.Insert(0)
+	small.Insert(2)
+	left, right := small.Split(cmp(1))
+	if left.Len() != 1 || right.Len() != 2 || small.Len() != 0 {
+		t.Fatalf("Split gave %d and %d items", left.Len(), right.Len())
+	}
+	left.Merge(right, nil)
+	if left.Len() != 3 || right.Len() != 0 {
+		t.Fatalf("Merge gave %d and %d items", left.Len(), right.Len())
+	}
+	if err := left.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package btree
+
+import "time"
+
+// Clock tells the time to the parts of this package that depend on it:
+// the recycle bin used by SoftDelete, Alarms, RangeWithin,
+// TimeSeries.ExpireOlderThan, and Maintainer statistics.
+// Tests can use a Clock they control to move time forward without waiting.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// SystemClock is the Clock that is used unless SetClock is called.
+// It returns time.Now.
+var SystemClock Clock = systemClock{}
+
+// SetClock makes the Tree get the time from c.  Trees made from t with Copy
+// or Clone use c as well.  Latency statistics (see TrackLatency) always use
+// the system clock, since they measure how long operations really take.
+// Passing nil goes back to SystemClock.
+func (t *Tree[T]) SetClock(c Clock) {
+	t.clock = c
+}
+
+func (t *Tree[T]) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
@@ -0,0 +1,240 @@
+// Command btreegen generates typed ordering functions and queries for
+// using a struct type with btree.  It is meant to be run by go generate:
+//
+//	//go:generate btreegen -type Event -fields Tenant,-When
+//
+// For each field list, btreegen writes a LessThan, a three-way comparison,
+// a CompareAgainst builder, a Tree constructor, and functions that build the
+// start and stop bounds for queries on each prefix of the fields.  Fields are
+// compared with < and > unless they are time.Time values, and a field
+// prefixed with - is sorted in descending order.
+//
+// With -type Event -fields Tenant,-When the generated functions are:
+//
+//	func EventByTenantWhenLess(a, b Event) bool
+//	func EventByTenantWhenCompare(a, b Event) int
+//	func EventByTenantWhenCmp(ref Event) btree.CompareAgainst[Event]
+//	func NewEventByTenantWhen() *btree.Tree[Event]
+//	func EventByTenantWhenWhereTenant(tenant string) (start, stop btree.Test[Event])
+//	func EventByTenantWhenWhereTenantWhen(tenant string, when time.Time) (start, stop btree.Test[Event])
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type field struct {
+	name, typ string
+	desc      bool
+}
+
+type ordering struct {
+	pkg, typ, name string
+	fields         []field
+	imports        map[string]string
+}
+
+func main() {
+	typ := flag.String("type", "", "name of the struct type to generate code for")
+	fields := flag.String("fields", "", "comma separated list of fields to order by, most significant first. Prefix a field with - to sort it in descending order")
+	name := flag.String("name", "", "name of the ordering. Defaults to the type name, By, and the field names")
+	output := flag.String("output", "", "file to write to. Defaults to the name in lower case followed by _btree.go")
+	dir := flag.String("dir", ".", "directory of the package holding the type")
+	flag.Parse()
+	if *typ == "" || *fields == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	o, err := load(*dir, *typ, strings.Split(*fields, ","))
+	if err != nil {
+		log.Fatalf("btreegen: %v", err)
+	}
+	o.name = *name
+	if o.name == "" {
+		o.name = o.typ + "By"
+		for _, f := range o.fields {
+			o.name += f.name
+		}
+	}
+	src, err := o.generate()
+	if err != nil {
+		log.Fatalf("btreegen: %v", err)
+	}
+	out := *output
+	if out == "" {
+		out = filepath.Join(*dir, strings.ToLower(o.name)+"_btree.go")
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatalf("btreegen: %v", err)
+	}
+}
+
+// load finds the struct type typ in the package in dir and looks up the types of fields.
+func load(dir, typ string, fields []string) (*ordering, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			st := findStruct(file, typ)
+			if st == nil {
+				continue
+			}
+			o := &ordering{pkg: pkg.Name, typ: typ, imports: map[string]string{}}
+			for _, spec := range file.Imports {
+				path := strings.Trim(spec.Path.Value, `"`)
+				name := filepath.Base(path)
+				if spec.Name != nil {
+					name = spec.Name.Name
+				}
+				o.imports[name] = path
+			}
+			for _, name := range fields {
+				f := field{name: strings.TrimPrefix(name, "-"), desc: strings.HasPrefix(name, "-")}
+				if f.typ = fieldType(st, f.name); f.typ == "" {
+					return nil, fmt.Errorf("%s has no field %s", typ, f.name)
+				}
+				o.fields = append(o.fields, f)
+			}
+			return o, nil
+		}
+	}
+	return nil, fmt.Errorf("no struct type %s in %s", typ, dir)
+}
+
+func findStruct(file *ast.File, typ string) (res *ast.StructType) {
+	ast.Inspect(file, func(n ast.Node) bool {
+		if spec, ok := n.(*ast.TypeSpec); ok && spec.Name.Name == typ {
+			res, _ = spec.Type.(*ast.StructType)
+		}
+		return res == nil
+	})
+	return
+}
+
+func fieldType(st *ast.StructType, name string) string {
+	for _, f := range st.Fields.List {
+		for _, n := range f.Names {
+			if n.Name == name {
+				var buf bytes.Buffer
+				format.Node(&buf, token.NewFileSet(), f.Type)
+				return buf.String()
+			}
+		}
+	}
+	return ""
+}
+
+// param turns a field name into a parameter name.
+func param(name string) string {
+	r := []rune(name)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+func (o *ordering) generate() ([]byte, error) {
+	var b bytes.Buffer
+	p := func(format string, args ...any) { fmt.Fprintf(&b, format, args...) }
+	imports := map[string]bool{"github.com/VictorLowther/btree": true}
+	for _, f := range o.fields {
+		if i := strings.Index(f.typ, "."); i > 0 {
+			if path, ok := o.imports[strings.TrimLeft(f.typ[:i], "*[]")]; ok {
+				imports[path] = true
+			}
+		}
+	}
+	paths := make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	p("// Code generated by btreegen. DO NOT EDIT.\n\npackage %s\n\nimport (\n", o.pkg)
+	// Standard library packages go in their own group first, as goimports would have it.
+	for _, std := range []bool{true, false} {
+		for _, path := range paths {
+			if !strings.Contains(strings.Split(path, "/")[0], ".") == std {
+				p("%q\n", path)
+			}
+		}
+		p("\n")
+	}
+	p(")\n\n")
+	p("// %sLess orders %s items by %s.\n", o.name, o.typ, o.describe(len(o.fields)))
+	p("func %sLess(a, b %s) bool { return %sCompare(a, b) < 0 }\n\n", o.name, o.typ, o.name)
+	p("// %sCompare is the three-way comparison for %sLess.\n", o.name, o.name)
+	p("func %sCompare(a, b %s) int {\n", o.name, o.typ)
+	o.compare(&b, len(o.fields), "a", "b")
+	p("}\n\n")
+	p("// %sCmp makes a CompareAgainst for ref that agrees with %sLess.\n", o.name, o.name)
+	p("func %sCmp(ref %s) btree.CompareAgainst[%s] {\n", o.name, o.typ, o.typ)
+	p("return func(item %s) int { return %sCompare(item, ref) }\n}\n\n", o.typ, o.name)
+	p("// New%s allocates a new Tree ordered by %sLess.\n", o.name, o.name)
+	p("func New%s() *btree.Tree[%s] { return btree.NewCmp(%sCompare) }\n", o.name, o.typ, o.name)
+	for n := 1; n <= len(o.fields); n++ {
+		var names, params []string
+		for _, f := range o.fields[:n] {
+			names = append(names, f.name)
+			params = append(params, param(f.name)+" "+f.typ)
+		}
+		fn := o.name + "Where" + strings.Join(names, "")
+		p("\n// %s returns start and stop bounds that select the items with the passed %s.\n", fn, strings.Join(names, ", "))
+		p("func %s(%s) (start, stop btree.Test[%s]) {\n", fn, strings.Join(params, ", "), o.typ)
+		p("var ref %s\n", o.typ)
+		for _, f := range o.fields[:n] {
+			p("ref.%s = %s\n", f.name, param(f.name))
+		}
+		p("cmp := func(item %s) int {\n", o.typ)
+		o.compare(&b, n, "item", "ref")
+		p("}\nreturn btree.Lt[%s](cmp), btree.Gt[%s](cmp)\n}\n", o.typ, o.typ)
+	}
+	return format.Source(b.Bytes())
+}
+
+func (o *ordering) describe(n int) string {
+	var res []string
+	for _, f := range o.fields[:n] {
+		if f.desc {
+			res = append(res, f.name+" descending")
+		} else {
+			res = append(res, f.name)
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// compare writes the body of a three-way comparison of the first n fields of a and b.
+func (o *ordering) compare(b *bytes.Buffer, n int, a, c string) {
+	for _, f := range o.fields[:n] {
+		x, y := a+"."+f.name, c+"."+f.name
+		if f.desc {
+			x, y = y, x
+		}
+		if f.typ == "time.Time" {
+			fmt.Fprintf(b, "switch {\ncase %s.Before(%s):\nreturn btree.Less\ncase %s.After(%s):\nreturn btree.Greater\n}\n", x, y, x, y)
+		} else {
+			fmt.Fprintf(b, "switch {\ncase %s < %s:\nreturn btree.Less\ncase %s > %s:\nreturn btree.Greater\n}\n", x, y, x, y)
+		}
+	}
+	fmt.Fprintf(b, "return btree.Equal\n")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	o, err := load("testdata/event", "Event", []string{"Tenant", "-When", "ID"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.name = "EventByTenantWhenID"
+	src, err := o.generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "event", "eventbytenantwhenid_btree.go")
+	expect, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, expect) {
+		t.Fatalf("Generated code does not match %s:\n%s", golden, src)
+	}
+	if _, err = load("testdata/event", "Event", []string{"Missing"}); err == nil {
+		t.Fatalf("Expected an error for a missing field")
+	}
+	if _, err = load("testdata/event", "Missing", []string{"ID"}); err == nil {
+		t.Fatalf("Expected an error for a missing type")
+	}
+}
+
+func TestGeneratedCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs go test on the generated code")
+	}
+	out, err := exec.Command("go", "test", "./testdata/event").CombinedOutput()
+	if err != nil {
+		t.Fatalf("Generated code failed its tests: %v\n%s", err, out)
+	}
+}
+
+func TestParam(t *testing.T) {
+	for in, expect := range map[string]string{"ID": "id", "Tenant": "tenant", "HTTPCode": "httpCode", "x": "x"} {
+		if got := param(in); got != expect {
+			t.Fatalf("param(%q) = %q, expected %q", in, got, expect)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+// Package event is used to test btreegen.
+package event
+
+import "time"
+
+//go:generate go run github.com/VictorLowther/btree/cmd/btreegen -type Event -fields Tenant,-When,ID
+
+type Event struct {
+	Tenant string
+	When   time.Time
+	ID     int64
+	Body   []byte
+}
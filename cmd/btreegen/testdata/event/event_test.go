@@ -0,0 +1,44 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventOrdering(t *testing.T) {
+	tree := NewEventByTenantWhenID()
+	defer tree.Release()
+	now := time.Now()
+	for i := 0; i < 12; i++ {
+		tree.Insert(Event{Tenant: []string{"a", "b", "c"}[i%3], When: now.Add(time.Duration(i%4) * time.Minute), ID: int64(i)})
+	}
+	var got []Event
+	start, stop := EventByTenantWhenIDWhereTenant("b")
+	tree.Range(start, stop, func(e Event) bool {
+		got = append(got, e)
+		return true
+	})
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 events for b, got %d", len(got))
+	}
+	for i := 1; i < len(got); i++ {
+		if !got[i-1].When.After(got[i].When) {
+			t.Fatalf("Events are not newest first: %v", got)
+		}
+	}
+	start, stop = EventByTenantWhenIDWhereTenantWhen("a", now.Add(3*time.Minute))
+	got = nil
+	tree.Range(start, stop, func(e Event) bool {
+		got = append(got, e)
+		return true
+	})
+	if len(got) != 1 || got[0].ID != 3 {
+		t.Fatalf("Unexpected events %v", got)
+	}
+	if e, found := tree.Get(EventByTenantWhenIDCmp(got[0])); !found || e.ID != 3 {
+		t.Fatalf("Failed to find event")
+	}
+	if !EventByTenantWhenIDLess(Event{Tenant: "a", When: now}, Event{Tenant: "a", When: now.Add(-time.Second)}) {
+		t.Fatalf("When should sort descending")
+	}
+}
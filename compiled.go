@@ -0,0 +1,179 @@
+package btree
+
+import "math/bits"
+
+// Compiled is a read-only copy of a Tree made by Compile.  It keeps its items
+// in a single slice in Eytzinger order, where the children of the item at
+// index k are at 2k and 2k+1.  That leaves out the pointers, heights, and sizes
+// a Tree keeps in each node, and keeps the top levels of every search in the
+// same few cache lines, so Compiled is smaller than a Tree and faster to
+// search.  Compiled is safe for concurrent use, since it never changes.
+type Compiled[T any] struct {
+	items []T
+	less  LessThan[T]
+	cmp   func(T, T) int
+}
+
+// Compile makes a Compiled copy of the items in t.  It takes O(n) time.
+// Later changes to t are not reflected in the Compiled copy.
+func (t *Tree[T]) Compile() *Compiled[T] {
+	t.mustInit()
+	return compile(t, t.count, func(fn Test[T]) { scan(t.view(), nil, nil, fn) })
+}
+
+// compile makes a Compiled ordered like t from the n items walk passes to
+// the function it is called with in ascending order.
+func compile[T any](t *Tree[T], n int, walk func(Test[T])) *Compiled[T] {
+	res := &Compiled[T]{items: make([]T, n+1), less: t.less, cmp: t.cmp}
+	k := res.first()
+	walk(func(v T) bool {
+		res.items[k] = v
+		k = res.next(k)
+		return true
+	})
+	return res
+}
+
+// Len returns the number of items in c.
+func (c *Compiled[T]) Len() int { return len(c.items) - 1 }
+
+// first returns the index of the smallest item, or 0 if c is empty.
+func (c *Compiled[T]) first() (k int) {
+	for k = 1; 2*k < len(c.items); k *= 2 {
+	}
+	return k % len(c.items)
+}
+
+// last returns the index of the largest item, or 0 if c is empty.
+func (c *Compiled[T]) last() (k int) {
+	for k = 1; 2*k+1 < len(c.items); k = 2*k + 1 {
+	}
+	return k % len(c.items)
+}
+
+// next returns the index of the item after the one at k, or 0 if there is none.
+func (c *Compiled[T]) next(k int) int {
+	if 2*k+1 < len(c.items) {
+		for k = 2*k + 1; 2*k < len(c.items); k *= 2 {
+		}
+		return k
+	}
+	return k >> (bits.TrailingZeros(^uint(k)) + 1)
+}
+
+// prev returns the index of the item before the one at k, or 0 if there is none.
+func (c *Compiled[T]) prev(k int) int {
+	if 2*k < len(c.items) {
+		for k *= 2; 2*k+1 < len(c.items); k = 2*k + 1 {
+		}
+		return k
+	}
+	return k >> (bits.TrailingZeros(uint(k)) + 1)
+}
+
+// Get returns the item in c that cmp returns Equal for and true,
+// or a zero T and false if there is no such item.
+func (c *Compiled[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
+	for k := 1; k < len(c.items); {
+		switch cmp(c.items[k]) {
+		case Greater:
+			k = 2 * k
+		case Less:
+			k = 2*k + 1
+		case Equal:
+			return c.items[k], true
+		default:
+			panic(unorderable)
+		}
+	}
+	return
+}
+
+// Has returns true if c holds an item that cmp returns Equal for.
+func (c *Compiled[T]) Has(cmp CompareAgainst[T]) bool {
+	_, found := c.Get(cmp)
+	return found
+}
+
+// Fetch returns the item in c equal to item and true,
+// or a zero T and false if there is no such item.
+func (c *Compiled[T]) Fetch(item T) (v T, found bool) {
+	if c.cmp != nil {
+		return c.Get(CmpFunc(item, c.cmp))
+	}
+	for k := 1; k < len(c.items); {
+		switch {
+		case c.less(item, c.items[k]):
+			k = 2 * k
+		case c.less(c.items[k], item):
+			k = 2*k + 1
+		default:
+			return c.items[k], true
+		}
+	}
+	return
+}
+
+// Min returns the smallest item in c and true, or a zero T and false if c is empty.
+func (c *Compiled[T]) Min() (item T, found bool) {
+	k := c.first()
+	return c.items[k], k != 0
+}
+
+// Max returns the largest item in c and true, or a zero T and false if c is empty.
+func (c *Compiled[T]) Max() (item T, found bool) {
+	k := c.last()
+	return c.items[k], k != 0
+}
+
+// Range calls iterator in ascending order for the items between start and stop,
+// which have the same meaning as for Tree.Range, stopping early if iterator returns false.
+func (c *Compiled[T]) Range(start, stop, iterator Test[T]) {
+	k := c.first()
+	if start != nil {
+		k = 1
+		for k < len(c.items) {
+			if start(c.items[k]) {
+				k = 2*k + 1
+			} else {
+				k = 2 * k
+			}
+		}
+		k >>= bits.TrailingZeros(^uint(k)) + 1
+	}
+	for ; k != 0; k = c.next(k) {
+		if (stop != nil && stop(c.items[k])) || !iterator(c.items[k]) {
+			return
+		}
+	}
+}
+
+// RangeDesc is Range in descending order.
+func (c *Compiled[T]) RangeDesc(start, stop, iterator Test[T]) {
+	k := c.last()
+	if stop != nil {
+		k = 1
+		for k < len(c.items) {
+			if stop(c.items[k]) {
+				k = 2 * k
+			} else {
+				k = 2*k + 1
+			}
+		}
+		k >>= bits.TrailingZeros(uint(k)) + 1
+	}
+	for ; k != 0; k = c.prev(k) {
+		if (start != nil && start(c.items[k])) || !iterator(c.items[k]) {
+			return
+		}
+	}
+}
+
+// After calls iterator in ascending order for the items that start returns false for.
+func (c *Compiled[T]) After(start, iterator Test[T]) { c.Range(start, nil, iterator) }
+
+// Before calls iterator in ascending order for the items that stop returns false for.
+func (c *Compiled[T]) Before(stop, iterator Test[T]) { c.Range(nil, stop, iterator) }
+
+// Walk calls iterator for every item in c in ascending order.
+func (c *Compiled[T]) Walk(iterator Test[T]) { c.Range(nil, nil, iterator) }
@@ -0,0 +1,108 @@
+package btree
+
+// KeyPart compares one part of a composite key.  Make them with
+// Asc, Desc, AscFunc, and DescFunc.
+type KeyPart[T any] func(a, b T) int
+
+// Asc makes a KeyPart that sorts items in ascending order of the value get returns.
+func Asc[T any, K Ordered](get func(T) K) KeyPart[T] {
+	return func(a, b T) int {
+		switch x, y := get(a), get(b); {
+		case x < y:
+			return Less
+		case y < x:
+			return Greater
+		default:
+			return Equal
+		}
+	}
+}
+
+// Desc makes a KeyPart that sorts items in descending order of the value get returns.
+func Desc[T any, K Ordered](get func(T) K) KeyPart[T] {
+	asc := Asc(get)
+	return func(a, b T) int { return asc(b, a) }
+}
+
+// AscFunc makes a KeyPart that sorts items in ascending order of the value get returns,
+// using cmp to compare values.  cmp is a three-way comparison function like the ones NewCmp takes.
+func AscFunc[T, K any](get func(T) K, cmp func(a, b K) int) KeyPart[T] {
+	return func(a, b T) int { return cmp(get(a), get(b)) }
+}
+
+// DescFunc is AscFunc in descending order.
+func DescFunc[T, K any](get func(T) K, cmp func(a, b K) int) KeyPart[T] {
+	return func(a, b T) int { return cmp(get(b), get(a)) }
+}
+
+// CompositeKey orders items by a list of KeyParts, using each part
+// to break ties left by the ones before it.  Its Less, Compare, and Cmp
+// methods always agree with each other, which keeps a LessThan and the
+// CompareAgainst functions used to search with it from drifting apart.
+//
+// Example:
+//
+//	byTenant := Composite(
+//		Asc(func(e Event) string { return e.Tenant }),
+//		Desc(func(e Event) int64 { return e.When }),
+//	)
+//	events := byTenant.New()
+//	latest, _ := events.Get(byTenant.Cmp(Event{Tenant: "acme", When: when}))
+type CompositeKey[T any] struct {
+	parts []KeyPart[T]
+}
+
+// Composite makes a CompositeKey from parts, with the most significant part first.
+func Composite[T any](parts ...KeyPart[T]) *CompositeKey[T] {
+	return &CompositeKey[T]{parts: parts}
+}
+
+// Compare returns a negative number if a sorts before b, a positive
+// number if a sorts after b, and 0 if they are equal.
+func (c *CompositeKey[T]) Compare(a, b T) int {
+	return c.compare(len(c.parts), a, b)
+}
+
+func (c *CompositeKey[T]) compare(parts int, a, b T) int {
+	for _, part := range c.parts[:parts] {
+		if res := part(a, b); res != Equal {
+			return res
+		}
+	}
+	return Equal
+}
+
+// Less is a LessThan for the CompositeKey.
+func (c *CompositeKey[T]) Less(a, b T) bool {
+	return c.Compare(a, b) < 0
+}
+
+// Cmp makes a CompareAgainst for ref that agrees with Less.
+func (c *CompositeKey[T]) Cmp(ref T) CompareAgainst[T] {
+	return CmpFunc(ref, c.Compare)
+}
+
+// Prefix returns start and stop Tests for Range and friends that bound the
+// items whose first n key parts are equal to those of ref.  The remaining
+// parts of ref are ignored, and the matching items stay in the order the
+// remaining parts give them.  Prefix panics if n is not between 0 and the number of parts.
+//
+// Example:
+//
+//	start, stop := byTenant.Prefix(Event{Tenant: "acme"}, 1)
+//	events.Range(start, stop, func(e Event) bool {
+//		// All the events for acme, newest first.
+//		return true
+//	})
+func (c *CompositeKey[T]) Prefix(ref T, n int) (start, stop Test[T]) {
+	if n < 0 || n > len(c.parts) {
+		panic("Prefix length out of range")
+	}
+	cmp := CmpFunc(ref, func(a, b T) int { return c.compare(n, a, b) })
+	return Lt(cmp), Gt(cmp)
+}
+
+// New allocates a new Tree ordered by the CompositeKey.
+func (c *CompositeKey[T]) New() *Tree[T] {
+	return NewCmp(c.Compare)
+}
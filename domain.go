@@ -0,0 +1,45 @@
+package btree
+
+// SetDomain makes the Tree check every item with validate before inserting it.
+// If validate returns an error, TryInsert and InsertNew return it without
+// changing the Tree, and Insert panics with it.  This catches items that are
+// put into the wrong Tree when items are routed between Trees by key, such as
+// when each Tree holds a range of keys.  Passing nil removes the check.
+func (t *Tree[T]) SetDomain(validate func(T) error) {
+	t.domain = validate
+}
+
+// SetKeyRange is SetDomain for a range of keys.  start and stop work like
+// they do for Range: items that start returns true for are below the range,
+// and items that stop returns true for are above it.  Items outside the range
+// are rejected with ErrOutOfDomain.  Either start or stop can be nil to
+// leave that end of the range open.
+func (t *Tree[T]) SetKeyRange(start, stop Test[T]) {
+	t.SetDomain(func(item T) error {
+		if (start != nil && start(item)) || (stop != nil && stop(item)) {
+			return ErrOutOfDomain
+		}
+		return nil
+	})
+}
+
+// domainOf returns the first error the Tree's domain check returns for
+// the items in other, or nil if they can all be inserted.
+func (t *Tree[T]) domainOf(other *Tree[T]) (err error) {
+	if t.domain != nil {
+		scan(other.view(), nil, nil, func(v T) bool {
+			err = t.domain(v)
+			return err == nil
+		})
+	}
+	return
+}
+
+// InDomain returns the error the Tree's domain check would return for item,
+// or nil if item can be inserted or the Tree has no domain check.
+func (t *Tree[T]) InDomain(item T) error {
+	if t.domain == nil {
+		return nil
+	}
+	return t.domain(item)
+}
@@ -0,0 +1,35 @@
+package btree
+
+import "errors"
+
+var (
+	// ErrCorrupt is wrapped by the errors Validate returns.
+	ErrCorrupt = errors.New("tree is corrupt")
+	// ErrQuotaExceeded is returned by TryInsert and InsertNew when adding an item would
+	// put a Tree over its Quota.
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrExists is returned by InsertNew when the Tree already holds an equal item.
+	ErrExists = errors.New("item already exists")
+	// ErrNotFound is returned by GetE when there is no matching item.
+	ErrNotFound = errors.New("item not found")
+	// ErrReleased is returned when a Tree is used after Release was called on it.
+	ErrReleased = errors.New("tree has been released")
+	// ErrNoOrdering is returned when a zero Tree of a type that has no default
+	// ordering is used before SetLess is called.
+	ErrNoOrdering = errors.New("tree has no ordering")
+	// ErrBoundsInverted is returned by CheckBounds when start and stop overlap,
+	// which usually means they were passed in the wrong order.
+	ErrBoundsInverted = errors.New("start and stop bounds are inverted")
+	// ErrModifiedDuringIteration is returned by Iterator.Err when the Tree was
+	// modified while it was being iterated over.
+	ErrModifiedDuringIteration = errors.New("tree modified during iteration")
+	// ErrIncompatibleOrdering is returned by CheckCompatible and the operations
+	// that combine Trees when the Trees are not ordered the same way.
+	ErrIncompatibleOrdering = errors.New("trees have incompatible orderings")
+	// ErrOutOfDomain is returned when an item outside the range set by SetKeyRange
+	// is inserted.
+	ErrOutOfDomain = errors.New("item is outside the tree's key range")
+	// ErrBackpressure can be returned by a write gate to reject a write
+	// when it has no more specific reason to give.
+	ErrBackpressure = errors.New("write rejected by backpressure")
+)
@@ -0,0 +1,171 @@
+package btree_test
+
+import (
+	"fmt"
+
+	"github.com/VictorLowther/btree"
+)
+
+func intTree(items ...int) *btree.Tree[int] {
+	t := btree.New(func(a, b int) bool { return a < b })
+	for _, i := range items {
+		t.Insert(i)
+	}
+	return t
+}
+
+func ExampleTree_Iterator() {
+	t := intTree(5, 3, 1, 4, 2)
+	defer t.Release()
+	iter := t.Iterator(nil, nil)
+	for iter.Next() {
+		fmt.Println(iter.Item())
+	}
+	// Iterating backwards starts from the largest item.
+	iter = t.Iterator(nil, nil)
+	for iter.Prev() {
+		fmt.Println(iter.Item())
+	}
+	// Output:
+	// 1
+	// 2
+	// 3
+	// 4
+	// 5
+	// 5
+	// 4
+	// 3
+	// 2
+	// 1
+}
+
+// The start and stop bounds say which items to leave out, so Lt makes the
+// start of a range inclusive and Lte makes it exclusive, while Gt makes the
+// end of a range inclusive and Gte makes it exclusive.
+func ExampleTree_Range() {
+	t := intTree(1, 2, 3, 4, 5, 6)
+	defer t.Release()
+	show := func(name string, start, stop btree.Test[int]) {
+		fmt.Print(name, ":")
+		t.Range(start, stop, func(i int) bool {
+			fmt.Print(" ", i)
+			return true
+		})
+		fmt.Println()
+	}
+	show("[2,5]", btree.Lt(t.Cmp(2)), btree.Gt(t.Cmp(5)))
+	show("[2,5)", btree.Lt(t.Cmp(2)), btree.Gte(t.Cmp(5)))
+	show("(2,5]", btree.Lte(t.Cmp(2)), btree.Gt(t.Cmp(5)))
+	show("(2,5)", btree.Lte(t.Cmp(2)), btree.Gte(t.Cmp(5)))
+	// Output:
+	// [2,5]: 2 3 4 5
+	// [2,5): 2 3 4
+	// (2,5]: 3 4 5
+	// (2,5): 3 4
+}
+
+// The Desc variants take the same bounds as Range, and only change the
+// order the items are visited in.
+func ExampleTree_RangeDesc() {
+	t := intTree(1, 2, 3, 4, 5, 6)
+	defer t.Release()
+	var items []int
+	t.RangeDesc(btree.Lt(t.Cmp(2)), btree.Gte(t.Cmp(5)), func(i int) bool {
+		items = append(items, i)
+		return true
+	})
+	fmt.Println(items)
+	// Output:
+	// [4 3 2]
+}
+
+func ExampleTree_After() {
+	t := intTree(1, 2, 3, 4, 5)
+	defer t.Release()
+	var after, before []int
+	t.After(btree.Lte(t.Cmp(3)), func(i int) bool {
+		after = append(after, i)
+		return true
+	})
+	t.Before(btree.Gt(t.Cmp(3)), func(i int) bool {
+		before = append(before, i)
+		return true
+	})
+	fmt.Println(after, before)
+	// Output:
+	// [4 5] [1 2 3]
+}
+
+type person struct {
+	Name string
+	Age  int
+}
+
+func ExampleTree_SortedClone() {
+	byName := btree.New(func(a, b person) bool { return a.Name < b.Name })
+	defer byName.Release()
+	for _, p := range []person{{"carol", 35}, {"alice", 30}, {"bob", 30}, {"dave", 25}} {
+		byName.Insert(p)
+	}
+	// People of the same age stay sorted by name.
+	byAge := byName.SortedClone(func(a, b person) bool { return a.Age < b.Age })
+	defer byAge.Release()
+	byAge.Walk(func(p person) bool {
+		fmt.Println(p.Age, p.Name)
+		return true
+	})
+	// Output:
+	// 25 dave
+	// 30 alice
+	// 30 bob
+	// 35 carol
+}
+
+func ExampleTree_Page() {
+	t := intTree(1, 2, 3, 4, 5, 6, 7)
+	defer t.Release()
+	var next btree.Test[int]
+	for more := true; more; {
+		var items []int
+		items, next, more = t.Page(next, 3)
+		fmt.Println(items, more)
+	}
+	// Output:
+	// [1 2 3] true
+	// [4 5 6] true
+	// [7] false
+}
+
+func ExampleCompositeKey_Prefix() {
+	byAgeName := btree.Composite(
+		btree.Desc(func(p person) int { return p.Age }),
+		btree.Asc(func(p person) string { return p.Name }),
+	)
+	t := byAgeName.New()
+	defer t.Release()
+	for _, p := range []person{{"carol", 35}, {"bob", 30}, {"alice", 30}, {"dave", 25}} {
+		t.Insert(p)
+	}
+	start, stop := byAgeName.Prefix(person{Age: 30}, 1)
+	t.Range(start, stop, func(p person) bool {
+		fmt.Println(p.Name)
+		return true
+	})
+	// Output:
+	// alice
+	// bob
+}
+
+func ExampleTree_Get() {
+	t := intTree(10, 20, 30)
+	defer t.Release()
+	if v, found := t.Get(t.Cmp(20)); found {
+		fmt.Println("found", v)
+	}
+	if _, found := t.Get(t.Cmp(25)); !found {
+		fmt.Println("25 is missing")
+	}
+	// Output:
+	// found 20
+	// 25 is missing
+}
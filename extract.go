@@ -0,0 +1,163 @@
+package btree
+
+import (
+	"math/bits"
+	"time"
+)
+
+// ExtractRange removes every item within the bounds set by start and stop
+// (as with Range) from t, and returns a new Tree with the same ordering and
+// node pool as t that holds the removed items.
+func (t *Tree[T]) ExtractRange(start, stop Test[T]) *Tree[T] {
+	return t.extract(start, stop, nil)
+}
+
+// ExtractIf removes every item that pred returns true for from t, and
+// returns a new Tree with the same ordering and node pool as t that holds
+// the removed items.  pred must not modify t.
+func (t *Tree[T]) ExtractIf(pred Test[T]) *Tree[T] {
+	return t.extract(nil, nil, pred)
+}
+
+// DeleteRange removes every item within the bounds set by start and stop
+// (as with Range) from t, and returns how many items were removed.
+// It splits the range out of t and joins the rest back together as Split
+// does, which relinks O(log n) nodes, and then frees the k removed nodes in
+// O(k) time.  If rebalancing is deferred, t is rebalanced first.
+// If SoftDelete has been called, the removed items are moved to the recycle bin.
+func (t *Tree[T]) DeleteRange(start, stop Test[T]) int {
+	if t.grow() == nil {
+		return 0
+	}
+	if t.deferred {
+		t.Rebalance()
+	}
+	var left, right *node[T]
+	mid := t.root
+	if start != nil {
+		left, mid = split(mid, start)
+	}
+	if stop != nil {
+		mid, right = split(mid, func(v T) bool { return !stop(v) })
+	}
+	if t.root = join2(left, right); t.root != nil {
+		t.root.p = nil
+	}
+	removed := mid.size()
+	t.dropAll(mid, t.now())
+	return removed
+}
+
+// DeleteIf removes every item that pred returns true for from t in a single
+// pass, and returns how many items were removed.  pred must not modify t.
+// The nodes that are kept are relinked into a balanced tree, which takes O(n)
+// time no matter how many items are removed.  Like DeleteRange, it moves the
+// removed items to the recycle bin if SoftDelete has been called.
+func (t *Tree[T]) DeleteIf(pred Test[T]) int {
+	if t.grow() == nil {
+		return 0
+	}
+	kept := make([]*node[T], 0, t.count)
+	var gone []*node[T]
+	iter := t.Iterator(nil, nil)
+	for iter.Next() {
+		if n := iter.workingNode; pred(n.i) {
+			gone = append(gone, n)
+		} else {
+			kept = append(kept, n)
+		}
+	}
+	if len(gone) == 0 {
+		return 0
+	}
+	if t.root = relink(kept); t.root != nil {
+		t.root.p = nil
+	}
+	now := t.now()
+	for _, n := range gone {
+		t.drop(n, now)
+	}
+	return len(gone)
+}
+
+// drop frees a node that has been unlinked from t, recycling and flushing
+// its item as Delete would.
+func (t *Tree[T]) drop(n *node[T], now time.Time) {
+	deleted := n.i
+	t.putNode(n)
+	if t.recycle != nil {
+		t.recycle.add(t, deleted, now)
+	}
+	t.flush(deleted, true)
+}
+
+// dropAll drops every node in the detached subtree rooted at n.
+func (t *Tree[T]) dropAll(n *node[T], now time.Time) {
+	if n == nil {
+		return
+	}
+	l, r := n.l, n.r
+	t.drop(n, now)
+	t.dropAll(l, now)
+	t.dropAll(r, now)
+}
+
+// extract removes the items between start and stop that match
+// accepts (or all of them if match is nil) and returns them in a new Tree.
+// When only a few items are being removed they are deleted one at a time.
+// Otherwise, the nodes are split into two lists in a single pass and relinked
+// into two balanced trees, which takes O(n) time no matter how many items are removed.
+func (t *Tree[T]) extract(start, stop, match Test[T]) *Tree[T] {
+	var victims []T
+	scan(t.grow(), start, stop, func(v T) bool {
+		if match == nil || match(v) {
+			victims = append(victims, v)
+		}
+		return true
+	})
+	res := t.Copy()
+	if len(victims) == 0 {
+		return res
+	}
+	if len(victims)*bits.Len(uint(t.count)) < t.count {
+		for _, v := range victims {
+			if deleted, found := t.remove(v); found {
+				t.flush(deleted, true)
+			}
+		}
+		res.root = res.build(victims)
+		return res
+	}
+	kept := make([]*node[T], 0, t.count-len(victims))
+	removed := make([]*node[T], 0, len(victims))
+	iter := t.Iterator(nil, nil)
+	for iter.Next() {
+		n := iter.workingNode
+		if len(removed) == len(victims) || t.less(n.i, victims[len(removed)]) {
+			kept = append(kept, n)
+			continue
+		}
+		removed = append(removed, n)
+		if t.quota != nil {
+			t.quota.items--
+			t.quota.bytes -= t.quota.size(n.i)
+		}
+		if t.access != nil {
+			delete(t.access.hits, n)
+		}
+	}
+	if t.root = relink(kept); t.root != nil {
+		t.root.p = nil
+	}
+	res.root = relink(removed)
+	res.root.p = nil
+	t.count -= len(removed)
+	t.removeCount += uint64(len(removed))
+	t.version++
+	res.count = len(removed)
+	res.insertCount = uint64(len(removed))
+	for _, n := range removed {
+		t.flush(n.i, true)
+	}
+	return res
+}
@@ -0,0 +1,203 @@
+package btree
+
+import "sync"
+
+type ordering[T any] struct {
+	less LessThan[T]
+	cmp  func(T, T) int
+}
+
+// Forest manages a collection of Trees that share a pool of nodes and
+// a registry of named orderings.  It is useful when an application keeps
+// lots of small Trees around, such as one or more per tenant.
+// Like Tree, Forest is not safe for concurrent use.
+type Forest[T any] struct {
+	nodePool  *sync.Pool
+	orderings map[string]ordering[T]
+	trees     map[*Tree[T]]struct{}
+}
+
+// ForestStats holds aggregate statistics for all the Trees in a Forest.
+type ForestStats struct {
+	Trees, Items     int
+	Inserts, Deletes uint64
+}
+
+// NewForest allocates a new, empty Forest.
+func NewForest[T any]() *Forest[T] {
+	return &Forest[T]{
+		nodePool:  &sync.Pool{New: func() any { return &node[T]{} }},
+		orderings: map[string]ordering[T]{},
+		trees:     map[*Tree[T]]struct{}{},
+	}
+}
+
+// Register saves lt under name for use by New.
+// Registering a name again replaces its ordering for Trees created afterwards.
+func (f *Forest[T]) Register(name string, lt LessThan[T]) {
+	f.orderings[name] = ordering[T]{less: lt}
+}
+
+// RegisterCmp is Register for three-way comparison functions, as taken by NewCmp.
+func (f *Forest[T]) RegisterCmp(name string, cmp func(a, b T) int) {
+	f.orderings[name] = ordering[T]{less: func(a, b T) bool { return cmp(a, b) < 0 }, cmp: cmp}
+}
+
+// New makes a new empty Tree in the Forest that is ordered by the ordering
+// registered under name.  New will panic if nothing is registered under name.
+func (f *Forest[T]) New(name string) *Tree[T] {
+	o, ok := f.orderings[name]
+	if !ok {
+		panic("No ordering registered as " + name)
+	}
+	res := New[T](o.less)
+	res.cmp = o.cmp
+	res.nodePool = f.nodePool
+	f.trees[res] = struct{}{}
+	return res
+}
+
+// Len returns the number of Trees in the Forest.
+func (f *Forest[T]) Len() int { return len(f.trees) }
+
+// Walk calls fn for each Tree in the Forest in no particular order,
+// stopping early if fn returns false.
+func (f *Forest[T]) Walk(fn func(*Tree[T]) bool) {
+	for t := range f.trees {
+		if !fn(t) {
+			return
+		}
+	}
+}
+
+// Stats returns statistics summed over all the Trees in the Forest.
+func (f *Forest[T]) Stats() (res ForestStats) {
+	res.Trees = len(f.trees)
+	for t := range f.trees {
+		res.Items += t.count
+		res.Inserts += t.insertCount
+		res.Deletes += t.removeCount
+	}
+	return
+}
+
+// Drop releases t and removes it from the Forest.
+func (f *Forest[T]) Drop(t *Tree[T]) {
+	if _, ok := f.trees[t]; ok {
+		delete(f.trees, t)
+		t.Release()
+	}
+}
+
+// Release releases every Tree in the Forest.
+// The Forest can still be used to make new Trees afterwards.
+func (f *Forest[T]) Release() {
+	for t := range f.trees {
+		t.Release()
+	}
+	f.trees = map[*Tree[T]]struct{}{}
+}
+
+// Quota limits the number of items and bytes that one or more Trees in a Forest
+// may hold between them.  Trees are placed under a Quota with SetQuota.
+type Quota[T any] struct {
+	// MaxItems is the most items the Trees may hold.  0 means no limit.
+	MaxItems int
+	// MaxBytes is the most bytes the Trees may hold, as measured by Size.  0 means no limit.
+	MaxBytes int64
+	// Size returns the number of bytes an item should be charged for.
+	// It must be set if MaxBytes is.
+	Size func(T) int64
+	// OnExceed is called by Insert when inserting item into t would
+	// exceed the Quota.  If it returns true, the item will be inserted
+	// anyway.  OnExceed may remove items from Trees to make room.
+	// If OnExceed is nil or returns false, the item is not inserted.
+	OnExceed func(t *Tree[T], item T) bool
+	items    int
+	bytes    int64
+}
+
+// Items returns the number of items currently charged to the Quota.
+func (q *Quota[T]) Items() int { return q.items }
+
+// Bytes returns the number of bytes currently charged to the Quota.
+func (q *Quota[T]) Bytes() int64 { return q.bytes }
+
+func (q *Quota[T]) size(item T) int64 {
+	if q.Size == nil {
+		return 0
+	}
+	return q.Size(item)
+}
+
+func (q *Quota[T]) allows(t *Tree[T], item T) bool {
+	items, bytes := q.items, q.bytes+q.size(item)
+	if old, found := t.find(item); found {
+		bytes -= q.size(old)
+	} else {
+		items++
+	}
+	return (q.MaxItems <= 0 || items <= q.MaxItems) && (q.MaxBytes <= 0 || bytes <= q.MaxBytes)
+}
+
+func (q *Quota[T]) admit(t *Tree[T], item T) bool {
+	return q.allows(t, item) || (q.OnExceed != nil && q.OnExceed(t, item))
+}
+
+// SetQuota places t under q, charging q for everything already in t.
+// Several Trees may share the same Quota to limit a tenant as a whole.
+// Passing a nil q removes t from its current Quota.
+func (f *Forest[T]) SetQuota(t *Tree[T], q *Quota[T]) {
+	if old := t.quota; old != nil {
+		t.quota = nil
+		old.items -= t.count
+		t.Walk(func(item T) bool {
+			old.bytes -= old.size(item)
+			return true
+		})
+	}
+	if q == nil {
+		return
+	}
+	t.quota = q
+	q.items += t.count
+	t.Walk(func(item T) bool {
+		q.bytes += q.size(item)
+		return true
+	})
+}
+
+// TryInsert is like Insert, except that it returns ErrQuotaExceeded instead
+// of calling the OnExceed function of the Tree's Quota when item would not fit,
+// and ErrReleased if the Tree has been released.  If the Tree has a write gate
+// (see SetWriteGate), any error it returns is returned as well.
+// Zero Trees that cannot be ordered get ErrNoOrdering, and items that fail
+// the Tree's domain check (see SetDomain) get the error it returns.
+func (t *Tree[T]) TryInsert(item T) error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	if t.domain != nil {
+		if err := t.domain(item); err != nil {
+			return err
+		}
+	}
+	if err := t.callGate(); err != nil {
+		return err
+	}
+	if t.quota != nil && !t.quota.allows(t, item) {
+		return ErrQuotaExceeded
+	}
+	t.insertItem(item)
+	if t.hitters != nil {
+		t.hitters.accessed(item)
+	}
+	if t.logger != nil {
+		t.checkInsert(item)
+	}
+	if t.alarms != nil {
+		t.checkAlarms()
+	}
+	t.flush(item, false)
+	return nil
+}
@@ -0,0 +1,49 @@
+package btree
+
+import "unsafe"
+
+// WriteInfo describes the state of a Tree to a write gate.
+type WriteInfo struct {
+	// Len is the number of items in the Tree.
+	Len int
+	// Dirty is the number of items marked by MarkDirty that have not been flushed yet.
+	Dirty int
+	// Deferred is true if rebalancing is deferred until Rebalance is called.
+	Deferred bool
+	// Bytes estimates the memory used by the Tree's nodes, not counting any
+	// memory the items themselves refer to.
+	Bytes int64
+}
+
+// SetWriteGate makes every write that can add items to the Tree call gate
+// first.  gate can slow writers down by blocking before it returns, or reject
+// the write by returning an error.  Writes that return errors, which are
+// TryInsert, InsertNew, MergeE, and Restore, return the error from gate unchanged
+// without changing the Tree.  Writes that cannot return an error, which are
+// Insert, Merge, Overlay and Hybrid inserts, Overlay compaction, and items
+// added by a Loader, wait for gate but carry on whatever it returns.
+// Gates that have no more specific error to return can use ErrBackpressure.
+//
+// Writes that only remove items do not call gate.  Load, LoadRows, Build,
+// and the set operations make new Trees, which have no gate.
+// Passing nil removes any existing gate.
+func (t *Tree[T]) SetWriteGate(gate func(WriteInfo) error) {
+	t.gate = gate
+}
+
+// callGate calls the write gate, if the Tree has one, and returns its error.
+func (t *Tree[T]) callGate() error {
+	if t.gate == nil {
+		return nil
+	}
+	return t.gate(t.writeInfo())
+}
+
+func (t *Tree[T]) writeInfo() WriteInfo {
+	return WriteInfo{
+		Len:      t.count,
+		Dirty:    t.DirtyCount(),
+		Deferred: t.deferred,
+		Bytes:    int64(t.count) * int64(unsafe.Sizeof(node[T]{})),
+	}
+}
@@ -0,0 +1,90 @@
+package btree
+
+import "sort"
+
+// Hit is an item reported by TopAccessed along with an estimate of how
+// many times it was accessed.
+type Hit[T any] struct {
+	Item T
+	// Count is at least the number of times Item was accessed, and
+	// Count-Error is at most that number.
+	Count, Error uint64
+}
+
+// heavyHitters is a space-saving sketch.  It keeps counters for at most
+// cap(counters) items, sorted by item so they can be found with a binary
+// search.  An item without a counter takes over the smallest one.
+type heavyHitters[T any] struct {
+	less     LessThan[T]
+	counters []Hit[T]
+}
+
+// TrackHeavyHitters makes the Tree estimate which items are accessed most
+// often by Get, Fetch, Insert, and TryInsert, using the space-saving
+// algorithm with k counters.  Any item accessed more than 1/k of the time
+// is guaranteed to be reported by TopAccessed.  Counting an item that
+// already has a counter takes O(log k) time, and taking a counter over
+// takes O(k) time, so k should be kept to a few hundred at most.
+// Deleting an item does not remove its counter.
+// The counters are updated on every lookup, so as with TrackAccess, Get and
+// Fetch need an exclusive lock while tracking is on.
+// Calling TrackHeavyHitters with k <= 0 stops tracking and discards the counts.
+func (t *Tree[T]) TrackHeavyHitters(k int) {
+	t.mustInit()
+	t.hitters = nil
+	if k > 0 {
+		t.hitters = &heavyHitters[T]{less: t.less, counters: make([]Hit[T], 0, k)}
+	}
+}
+
+func (h *heavyHitters[T]) accessed(item T) {
+	c := h.counters
+	i := sort.Search(len(c), func(i int) bool { return !h.less(c[i].Item, item) })
+	if i < len(c) && !h.less(item, c[i].Item) {
+		c[i].Count++
+		return
+	}
+	if len(c) < cap(c) {
+		c = append(c, Hit[T]{})
+		copy(c[i+1:], c[i:])
+		c[i] = Hit[T]{Item: item, Count: 1}
+		h.counters = c
+		return
+	}
+	low := 0
+	for j := range c {
+		if c[j].Count < c[low].Count {
+			low = j
+		}
+	}
+	hit := Hit[T]{Item: item, Count: c[low].Count + 1, Error: c[low].Count}
+	// Move the counters between low and i over by one to keep them sorted.
+	if low < i {
+		i--
+		copy(c[low:i], c[low+1:i+1])
+	} else {
+		copy(c[i+1:low+1], c[i:low])
+	}
+	c[i] = hit
+}
+
+// reorder sorts the counters by less, for when the order of the Tree changes.
+func (h *heavyHitters[T]) reorder(less LessThan[T]) {
+	h.less = less
+	sort.Slice(h.counters, func(i, j int) bool { return less(h.counters[i].Item, h.counters[j].Item) })
+}
+
+// TopAccessed returns up to n of the items accessed most often since
+// TrackHeavyHitters was called, from most to least accessed.
+// It returns nil if heavy hitters are not being tracked.
+func (t *Tree[T]) TopAccessed(n int) []Hit[T] {
+	if t.hitters == nil || n <= 0 {
+		return nil
+	}
+	res := append([]Hit[T](nil), t.hitters.counters...)
+	sort.SliceStable(res, func(i, j int) bool { return res[i].Count > res[j].Count })
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
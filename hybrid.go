@@ -0,0 +1,171 @@
+package btree
+
+import "unsafe"
+
+// Hybrid pairs a Compiled base with a small Tree of changes, so that data that
+// rarely changes can be searched at Compiled speed without giving up Insert
+// and Delete.  Inserted items go into the delta Tree, and deleting an item that
+// is in the base leaves a tombstone for it.  Recompile folds the changes into
+// a new base, and is meant to be run every so often as a Maintainer task.
+// Like Tree, Hybrid is not safe for concurrent use.
+//
+// Example:
+//
+//	h := NewHybrid(tree)
+//	m.Add("recompile", h.Recompile)
+type Hybrid[T any] struct {
+	base       *Compiled[T]
+	puts, dels *Tree[T]
+	count      int
+	gate       func(WriteInfo) error
+}
+
+// NewHybrid makes a Hybrid whose base is compiled from t, and whose
+// delta Trees are made from t with Copy.  h keeps the write gate of t,
+// if it has one.  t is not used after that.
+func NewHybrid[T any](t *Tree[T]) *Hybrid[T] {
+	return &Hybrid[T]{base: t.Compile(), puts: t.Copy(), dels: t.Copy(), count: t.count, gate: t.gate}
+}
+
+// Len returns the number of items in h.
+func (h *Hybrid[T]) Len() int { return h.count }
+
+// DeltaLen returns the number of changes that have not been compiled into the base yet.
+func (h *Hybrid[T]) DeltaLen() int { return h.puts.count + h.dels.count }
+
+// Base returns the Compiled base.  It does not reflect changes made since
+// the last Recompile, and can be searched concurrently with changes to h.
+func (h *Hybrid[T]) Base() *Compiled[T] { return h.base }
+
+// present returns true if h holds an item equal to item.
+func (h *Hybrid[T]) present(item T) bool {
+	if _, found := h.puts.Fetch(item); found {
+		return true
+	}
+	if _, found := h.base.Fetch(item); found {
+		_, deleted := h.dels.Fetch(item)
+		return !deleted
+	}
+	return false
+}
+
+// Get returns the item in h that cmp returns Equal for and true,
+// or a zero T and false if there is no such item.
+func (h *Hybrid[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
+	if item, found = h.puts.Get(cmp); found {
+		return
+	}
+	if item, found = h.base.Get(cmp); found && h.dels.Has(cmp) {
+		var ref T
+		return ref, false
+	}
+	return
+}
+
+// Has returns true if h holds an item that cmp returns Equal for.
+func (h *Hybrid[T]) Has(cmp CompareAgainst[T]) bool {
+	_, found := h.Get(cmp)
+	return found
+}
+
+// Insert adds item to h, replacing any equal item.  If h has a write gate,
+// Insert waits for it, but ignores any error it returns.  The gate is told
+// how many items h holds, and Bytes counts both the base and the changes.
+func (h *Hybrid[T]) Insert(item T) {
+	if h.gate != nil {
+		h.gate(WriteInfo{
+			Len:   h.count,
+			Bytes: int64(h.base.Len())*int64(unsafe.Sizeof(item)) + h.puts.writeInfo().Bytes + h.dels.writeInfo().Bytes,
+		})
+	}
+	if !h.present(item) {
+		h.count++
+	}
+	h.dels.Delete(item)
+	h.puts.Insert(item)
+}
+
+// Delete removes the item equal to item from h, returning the deleted item and true,
+// or a zero T and false if there was no such item.
+func (h *Hybrid[T]) Delete(item T) (deleted T, found bool) {
+	deleted, found = h.puts.Delete(item)
+	if v, inBase := h.base.Fetch(item); inBase {
+		if _, gone := h.dels.Fetch(item); !gone {
+			if !found {
+				deleted, found = v, true
+			}
+			h.dels.Insert(item)
+		}
+	}
+	if found {
+		h.count--
+	}
+	return
+}
+
+// Range calls iterator in ascending order for the items between start and stop,
+// which have the same meaning as for Tree.Range, stopping early if iterator
+// returns false.  The base and the changes are merged as they are iterated over.
+func (h *Hybrid[T]) Range(start, stop, iterator Test[T]) {
+	lt := h.puts.less
+	pc, dc := h.puts.cursorIn(start, stop), h.dels.cursorIn(start, stop)
+	defer pc.iter.Release()
+	defer dc.iter.Release()
+	done := false
+	h.base.Range(start, stop, func(v T) bool {
+		for pc.ok && lt(pc.item(), v) {
+			if !iterator(pc.item()) {
+				done = true
+				return false
+			}
+			pc.next()
+		}
+		if pc.at(lt, v) {
+			v = pc.item()
+			pc.next()
+		} else {
+			for dc.ok && lt(dc.item(), v) {
+				dc.next()
+			}
+			if dc.at(lt, v) {
+				return true
+			}
+		}
+		if !iterator(v) {
+			done = true
+			return false
+		}
+		return true
+	})
+	for ; !done && pc.ok; pc.next() {
+		if !iterator(pc.item()) {
+			return
+		}
+	}
+}
+
+// Walk calls iterator for every item in h in ascending order.
+func (h *Hybrid[T]) Walk(iterator Test[T]) { h.Range(nil, nil, iterator) }
+
+// Recompile folds the changes made since the last Recompile into a new base,
+// which takes O(n) time.  It does nothing if there are no changes.
+// It always returns nil, so that it can be passed straight to Maintainer.Add.
+func (h *Hybrid[T]) Recompile() error {
+	if h.DeltaLen() == 0 {
+		return nil
+	}
+	h.base = compile(h.puts, h.count, h.Walk)
+	for _, t := range []**Tree[T]{&h.puts, &h.dels} {
+		fresh := (*t).Copy()
+		(*t).Release()
+		*t = fresh
+	}
+	return nil
+}
+
+// Release releases the delta Trees.  h must not be used afterwards.
+func (h *Hybrid[T]) Release() {
+	h.puts.Release()
+	h.dels.Release()
+	h.base = nil
+}
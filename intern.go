@@ -0,0 +1,83 @@
+package btree
+
+import (
+	"sync"
+	"unsafe"
+)
+
+// internChunk is the size of the blocks an Interner copies strings into.
+// Strings longer than a quarter of that get a block of their own.
+const internChunk = 64 << 10
+
+// Interner keeps one copy of each distinct string it is given, so that
+// several Trees holding the same keys share their storage.  The copies
+// are packed into large blocks that are never freed while the Interner
+// is in use, so it is best suited to keys that mostly stay around.
+// An Interner is safe for concurrent use.
+type Interner struct {
+	mu    sync.Mutex
+	strs  map[string]string
+	arena []byte
+	stats InternStats
+}
+
+// InternStats describes the strings an Interner holds.
+type InternStats struct {
+	// Strings and Bytes are how many distinct strings the Interner holds
+	// and how many bytes they take up.
+	Strings int
+	Bytes   uint64
+	// Saved is how many bytes of duplicate strings were not kept because
+	// the Interner already had a copy.
+	Saved uint64
+}
+
+// NewInterner allocates a new, empty Interner.
+func NewInterner() *Interner {
+	return &Interner{strs: map[string]string{}}
+}
+
+// Intern returns the Interner's copy of s, making one if needed.
+func (in *Interner) Intern(s string) string {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if res, ok := in.strs[s]; ok {
+		in.stats.Saved += uint64(len(s))
+		return res
+	}
+	res := in.copy(s)
+	in.strs[res] = res
+	in.stats.Strings++
+	in.stats.Bytes += uint64(len(s))
+	return res
+}
+
+func (in *Interner) copy(s string) string {
+	if len(s) > internChunk/4 {
+		return string([]byte(s))
+	}
+	if len(s) > cap(in.arena)-len(in.arena) {
+		in.arena = make([]byte, 0, internChunk)
+	}
+	start := len(in.arena)
+	in.arena = append(in.arena, s...)
+	buf := in.arena[start:len(in.arena):len(in.arena)]
+	// The arena is only ever appended to, so the bytes behind buf never change.
+	return *(*string)(unsafe.Pointer(&buf))
+}
+
+// Stats returns statistics about the strings the Interner holds.
+func (in *Interner) Stats() InternStats {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.stats
+}
+
+// NewInternedString is like NewString, except that every string added
+// to the Tree is replaced with in's copy of it first.  Trees made with
+// Copy, Clone, and SortBy share in.
+func NewInternedString(in *Interner) *Tree[string] {
+	res := NewString()
+	res.intern = in.Intern
+	return res
+}
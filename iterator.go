@@ -1,5 +1,10 @@
 package btree
 
+import (
+	"runtime"
+	"time"
+)
+
 // Test is a function signature that is used for iterating through
 // a tree along with the signature that Range, Before, and After
 // discriminators must match.
@@ -8,6 +13,43 @@ type Test[T any] func(T) bool
 // TestMaker is a function that takes a CompareAgainst and makes a Test from it.
 type TestMaker[T any] func(CompareAgainst[T]) Test[T]
 
+// Ordered matches any type that supports the < operator.
+// It is the same as cmp.Ordered from newer versions of Go.
+type Ordered interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~string
+}
+
+// CmpOrdered makes a CompareAgainst for ref using the < operator.
+func CmpOrdered[T Ordered](ref T) CompareAgainst[T] {
+	return func(treeVal T) int {
+		switch {
+		case treeVal < ref:
+			return Less
+		case ref < treeVal:
+			return Greater
+		default:
+			return Equal
+		}
+	}
+}
+
+// CmpFunc makes a CompareAgainst for ref using a three-way comparison function
+// like the ones NewCmp takes.
+func CmpFunc[T any](ref T, cmp func(a, b T) int) CompareAgainst[T] {
+	return func(treeVal T) int {
+		switch c := cmp(treeVal, ref); {
+		case c < 0:
+			return Less
+		case c > 0:
+			return Greater
+		default:
+			return Equal
+		}
+	}
+}
+
 // Lt is a TestMaker that returns true if the item in the
 // tree being examined is less than the item the CompareAgainst function wraps.
 func Lt[T any](c CompareAgainst[T]) Test[T] {
@@ -49,10 +91,14 @@ func Ne[T any](c CompareAgainst[T]) Test[T] {
 // get undefined results and/or panics.
 type Iterator[T any] struct {
 	t           *Tree[T]
+	small       []T
+	pos         int
 	stack       []*node[T]
 	workingNode *node[T]
 	start, stop Test[T]
 	ascending   bool
+	version     uint64
+	err         error
 }
 
 func (i *Iterator[T]) clearStack() {
@@ -67,12 +113,20 @@ func (i *Iterator[T]) clearStack() {
 // calls to Item will panic.
 func (i *Iterator[T]) Release() {
 	i.clearStack()
+	i.small = nil
 	i.workingNode = nil
 	i.start = nil
 	i.stop = nil
 	i.t = nil
 }
 
+// Err returns ErrModifiedDuringIteration if the Iterator noticed that
+// the Tree was modified while it was being iterated over, and nil otherwise.
+// Results from an Iterator that returns an error from Err are not reliable.
+func (i *Iterator[T]) Err() error {
+	return i.err
+}
+
 func (i *Iterator[T]) stackHead() *node[T] {
 	switch idx := len(i.stack); idx {
 	case 0:
@@ -111,12 +165,34 @@ func (i *Iterator[T]) swapHead() {
 // Item returns the item that the current node points to.
 // It will panic if iteration has not yet started, or if iteration has finished.
 func (i *Iterator[T]) Item() T {
+	if i.small != nil && i.pos >= 0 {
+		return i.small[i.pos]
+	}
 	if len(i.stack) == 0 {
 		panic("No iteration in progress")
 	}
 	return i.workingNode.i
 }
 
+// ItemPtr returns a pointer to the item that the current node holds,
+// which avoids copying large items when scanning.  The item must be treated as read-only,
+// apart from fields that play no part in ordering the Tree, and the pointer
+// must not be used after the Tree is modified.
+// ItemPtr panics when Item would, and also if the Tree has been modified
+// since the Iterator was created.
+func (i *Iterator[T]) ItemPtr() *T {
+	if len(i.stack) == 0 && (i.small == nil || i.pos < 0) {
+		panic("No iteration in progress")
+	}
+	if i.err != nil || i.t.version != i.version {
+		panic(ErrModifiedDuringIteration)
+	}
+	if i.small != nil {
+		return &i.small[i.pos]
+	}
+	return &i.workingNode.i
+}
+
 func (i *Iterator[T]) pickNextNode(current, next, bound *node[T], boundCheck Test[T]) *node[T] {
 	if boundCheck != nil && boundCheck(current.i) {
 		return bound
@@ -138,6 +214,9 @@ func (i *Iterator[T]) max(n *node[T]) {
 }
 
 func (i *Iterator[T]) init(ascending bool, orNot Test[T]) bool {
+	if i.t != nil && i.t.latency != nil {
+		defer i.t.latency.iterate.record(time.Now())
+	}
 	if i.workingNode != nil {
 		if ascending {
 			i.min(i.workingNode)
@@ -157,6 +236,44 @@ func (i *Iterator[T]) init(ascending bool, orNot Test[T]) bool {
 	}
 }
 
+// stepSmall is Next and Prev for an Iterator over a Tree that keeps its
+// items in a slice.  i.pos is the index of the current item, or -1 before
+// iteration has started.  Like changeDirection, turning around skips the
+// item next to the current one.
+func (i *Iterator[T]) stepSmall(ascending bool) bool {
+	step := 1
+	if i.ascending != ascending {
+		step = 2
+	}
+	if !ascending {
+		step = -step
+	}
+	switch {
+	case i.pos >= 0:
+		i.pos += step
+	case ascending:
+		if i.t.latency != nil {
+			defer i.t.latency.iterate.record(time.Now())
+		}
+		for i.pos = 0; i.pos < len(i.small) && i.start != nil && i.start(i.small[i.pos]); i.pos++ {
+		}
+	default:
+		if i.t.latency != nil {
+			defer i.t.latency.iterate.record(time.Now())
+		}
+		for i.pos = len(i.small) - 1; i.pos >= 0 && i.stop != nil && i.stop(i.small[i.pos]); i.pos-- {
+		}
+	}
+	i.ascending = ascending
+	if i.pos < 0 || i.pos >= len(i.small) ||
+		(i.start != nil && i.start(i.small[i.pos])) ||
+		(i.stop != nil && i.stop(i.small[i.pos])) {
+		i.Release()
+		return false
+	}
+	return true
+}
+
 func (i *Iterator[T]) changeDirection() bool {
 	i.ascending = !i.ascending
 	i.clearStack()
@@ -187,6 +304,12 @@ func (i *Iterator[T]) changeDirection() bool {
 // If Next returns true, Item will return the item that
 // the current node contains.
 func (i *Iterator[T]) Next() bool {
+	if i.t != nil && i.t.version != i.version {
+		i.modified()
+	}
+	if i.small != nil {
+		return i.stepSmall(true)
+	}
 	if len(i.stack) == 0 {
 		return i.init(true, i.stop)
 	}
@@ -216,8 +339,14 @@ func (i *Iterator[T]) Next() bool {
 // If Prev returns true, Item will return the item that
 // the current node contains.
 func (i *Iterator[T]) Prev() bool {
+	if i.t != nil && i.t.version != i.version {
+		i.modified()
+	}
+	if i.small != nil {
+		return i.stepSmall(false)
+	}
 	if len(i.stack) == 0 {
-		return i.init(false, i.stop)
+		return i.init(false, i.start)
 	}
 	if i.ascending && !i.changeDirection() {
 		return false
@@ -265,10 +394,65 @@ func (i *Iterator[T]) Prev() bool {
 func (t *Tree[T]) Iterator(start, stop Test[T]) *Iterator[T] {
 	return &Iterator[T]{
 		t:           t,
+		small:       t.small,
+		pos:         -1,
 		workingNode: t.root,
 		start:       start,
 		stop:        stop,
+		version:     t.version,
+	}
+}
+
+// Pull returns functions for pulling items out of the Iterator one at a time,
+// in the same shape as iter.Pull in newer versions of Go.  Each call to next
+// advances the Iterator and returns the item it lands on and true, or a zero T
+// and false once there are no more items.  stop releases the Iterator.
+func (i *Iterator[T]) Pull() (next func() (T, bool), stop func()) {
+	next = func() (item T, ok bool) {
+		if ok = i.Next(); ok {
+			item = i.Item()
+		}
+		return
+	}
+	return next, i.Release
+}
+
+// Seq returns a function that calls yield with each of the remaining items
+// in ascending order, stopping early if yield returns false, and then
+// releases the Iterator.  It can be used as an iter.Seq in newer versions of Go.
+func (i *Iterator[T]) Seq() func(yield func(T) bool) {
+	return func(yield func(T) bool) {
+		defer i.Release()
+		for i.Next() {
+			if !yield(i.Item()) {
+				return
+			}
+		}
+	}
+}
+
+// IteratorByRank creates a new Iterator over the items whose rank (their
+// position in ascending order, starting at 0) is at least fromRank and less than toRank.
+// Ranks past the end of the Tree are clamped to Len.  As with Iterator, the bounds
+// are turned into Tests, so the Iterator covers the items between the ones that
+// held those ranks when it was created.
+func (t *Tree[T]) IteratorByRank(fromRank, toRank int) *Iterator[T] {
+	if fromRank < 0 {
+		fromRank = 0
+	}
+	if fromRank >= toRank || fromRank >= t.count {
+		return &Iterator[T]{t: t, version: t.version}
+	}
+	var start, stop Test[T]
+	if fromRank > 0 {
+		item, _ := t.At(fromRank)
+		start = Lt(t.Cmp(item))
 	}
+	if toRank < t.count {
+		item, _ := t.At(toRank)
+		stop = Gte(t.Cmp(item))
+	}
+	return t.Iterator(start, stop)
 }
 
 // Range will iterate through the tree in ascending order,
@@ -279,6 +463,11 @@ func (t *Tree[T]) Iterator(start, stop Test[T]) *Iterator[T] {
 // Lt  start == inclusive, Lte start == exclusive
 // Gte stop  == exclusive, Gt  stop  == inclusive
 func (t *Tree[T]) Range(start, stop, iterator Test[T]) {
+	iterator = t.chunked(iterator)
+	if t.small != nil {
+		t.scanSmall(start, stop, iterator)
+		return
+	}
 	i := t.Iterator(start, stop)
 	for i.Next() {
 		if !iterator(i.Item()) {
@@ -293,6 +482,11 @@ func (t *Tree[T]) Range(start, stop, iterator Test[T]) {
 //
 // Lt start == inclusive, Lte start = exclusive
 func (t *Tree[T]) After(start, iterator Test[T]) {
+	iterator = t.chunked(iterator)
+	if t.small != nil {
+		t.scanSmall(start, nil, iterator)
+		return
+	}
 	i := t.Iterator(start, nil)
 	for i.Next() {
 		if !iterator(i.Item()) {
@@ -307,6 +501,11 @@ func (t *Tree[T]) After(start, iterator Test[T]) {
 //
 // Gt stop == inclusive, Gte stop = exclusive
 func (t *Tree[T]) Before(stop, iterator Test[T]) {
+	iterator = t.chunked(iterator)
+	if t.small != nil {
+		t.scanSmall(nil, stop, iterator)
+		return
+	}
 	i := t.Iterator(nil, stop)
 	for i.Next() {
 		if !iterator(i.Item()) {
@@ -315,9 +514,43 @@ func (t *Tree[T]) Before(stop, iterator Test[T]) {
 	}
 }
 
+// RangeDesc will iterate through the same items as Range, but in descending order.
+// start and stop keep the same meaning they have for Range: start
+// still bounds the smallest items and stop still bounds the largest,
+// so RangeDesc begins just inside stop and ends just inside start.
+//
+// Lt  start == inclusive, Lte start == exclusive
+// Gte stop  == exclusive, Gt  stop  == inclusive
+func (t *Tree[T]) RangeDesc(start, stop, iterator Test[T]) {
+	scanDesc(t.view(), start, stop, t.chunked(iterator))
+}
+
+// AfterDesc will iterate in descending order from the largest item in the tree down
+// to the items on the left that start returns true for, which are ignored.
+// Iteration will also stop when iterator returns false.
+//
+// Lt start == inclusive, Lte start = exclusive
+func (t *Tree[T]) AfterDesc(start, iterator Test[T]) {
+	scanDesc(t.view(), start, nil, t.chunked(iterator))
+}
+
+// BeforeDesc will iterate in descending order, ignoring items on the right that stop
+// returns true for and continuing down to the smallest item in the tree.
+// Iteration will stop if iterator returns false.
+//
+// Gt stop == inclusive, Gte stop = exclusive
+func (t *Tree[T]) BeforeDesc(stop, iterator Test[T]) {
+	scanDesc(t.view(), nil, stop, t.chunked(iterator))
+}
+
 // Walk will call Iterator once for each item in the tree in ascending order.
 // Walk will return early if iterator returns false.
 func (t *Tree[T]) Walk(iterator Test[T]) {
+	iterator = t.chunked(iterator)
+	if t.small != nil {
+		t.scanSmall(nil, nil, iterator)
+		return
+	}
 	i := t.Iterator(nil, nil)
 	for i.Next() {
 		if !iterator(i.Item()) {
@@ -325,3 +558,230 @@ func (t *Tree[T]) Walk(iterator Test[T]) {
 		}
 	}
 }
+
+// YieldEvery makes Walk, Range, After, Before, and their Desc versions call
+// fn after every n items they visit, so that a long scan does not keep other
+// goroutines from running.  If fn is nil, runtime.Gosched is used.  fn must
+// not modify the Tree.  Passing n <= 0 turns yielding off.
+func (t *Tree[T]) YieldEvery(n int, fn func()) {
+	switch {
+	case n <= 0:
+		t.yieldEvery, t.yield = 0, nil
+	case fn == nil:
+		t.yieldEvery, t.yield = n, runtime.Gosched
+	default:
+		t.yieldEvery, t.yield = n, fn
+	}
+}
+
+// chunked wraps fn so that it calls the Tree's yield function every so often.
+func (t *Tree[T]) chunked(fn Test[T]) Test[T] {
+	if t.yield == nil {
+		return fn
+	}
+	every, yield, seen := t.yieldEvery, t.yield, 0
+	return func(v T) bool {
+		if !fn(v) {
+			return false
+		}
+		if seen++; seen == every {
+			seen = 0
+			yield()
+		}
+		return true
+	}
+}
+
+// Page returns up to limit items in ascending order, ignoring items on the left
+// that start returns true for.  next is the start Test for the following page,
+// and hasMore reports whether there are items past the end of this one.
+// If limit is not positive, Page returns no items.
+//
+// Example:
+//
+//	items, next, more := tree.Page(nil, 50)
+//	for more {
+//		items, next, more = tree.Page(next, 50)
+//	}
+func (t *Tree[T]) Page(start Test[T], limit int) (items []T, next Test[T], hasMore bool) {
+	next = start
+	if limit <= 0 {
+		return
+	}
+	items = make([]T, 0, limit)
+	scan(t.view(), start, nil, func(v T) bool {
+		if len(items) == limit {
+			hasMore = true
+			return false
+		}
+		items = append(items, v)
+		return true
+	})
+	if len(items) > 0 {
+		next = Lte(t.Cmp(items[len(items)-1]))
+	}
+	return
+}
+
+// deadlineCheckEvery is how many items RangeWithin visits between looking at the clock.
+const deadlineCheckEvery = 64
+
+// RangeWithin is like Range, except that it gives up once budget has run out.
+// If it gives up, it returns a start Test that will resume iteration after the
+// last item it visited, and false.  Otherwise, it returns nil and true,
+// including when iterator stopped the iteration.  The clock is only checked
+// every few dozen items, so RangeWithin may run a little over budget.
+func (t *Tree[T]) RangeWithin(budget time.Duration, start, stop, iterator Test[T]) (resume Test[T], complete bool) {
+	deadline := t.now().Add(budget)
+	iterator = t.chunked(iterator)
+	var last T
+	seen, expired := 0, false
+	scan(t.view(), start, stop, func(v T) bool {
+		if !iterator(v) {
+			return false
+		}
+		last = v
+		if seen++; seen%deadlineCheckEvery == 0 && !t.now().Before(deadline) {
+			expired = true
+			return false
+		}
+		return true
+	})
+	if expired {
+		return Lte(t.Cmp(last)), false
+	}
+	return nil, true
+}
+
+// scan calls fn for each item in the subtree rooted at n in ascending
+// order, skipping items on the left that start returns true for and stopping at
+// the first item on the right that stop returns true for.
+// Unlike an Iterator, scan does not allocate.  It returns false if
+// iteration was stopped by fn or stop.
+func scan[T any](n *node[T], start, stop, fn Test[T]) bool {
+	for n != nil {
+		if start != nil && start(n.i) {
+			n = n.r
+			continue
+		}
+		if !scan(n.l, start, stop, fn) {
+			return false
+		}
+		if (stop != nil && stop(n.i)) || !fn(n.i) {
+			return false
+		}
+		// Everything to the right is past start, no need to check it again.
+		start = nil
+		n = n.r
+	}
+	return true
+}
+
+// scanDesc is scan in descending order.  It skips items on the right that
+// stop returns true for, and stops at the first item on the left that start returns true for.
+func scanDesc[T any](n *node[T], start, stop, fn Test[T]) bool {
+	for n != nil {
+		if stop != nil && stop(n.i) {
+			n = n.l
+			continue
+		}
+		if !scanDesc(n.r, start, stop, fn) {
+			return false
+		}
+		if (start != nil && start(n.i)) || !fn(n.i) {
+			return false
+		}
+		stop = nil
+		n = n.l
+	}
+	return true
+}
+
+// Reduce calls fn for each item in t in ascending order that is within the bounds
+// set by start and stop (as with Range), passing along an accumulated value that
+// starts as init, and returns the final accumulated value.  Reduce itself
+// allocates nothing, whether the Tree keeps its items in nodes or, while it is
+// small, in a slice.
+//
+// Example:
+//
+//	sum := Reduce(tree, nil, nil, 0, func(acc, v int) int { return acc + v })
+func Reduce[T, A any](t *Tree[T], start, stop Test[T], init A, fn func(A, T) A) A {
+	acc := init
+	step := func(v T) bool {
+		acc = fn(acc, v)
+		return true
+	}
+	if t.small != nil {
+		t.scanSmall(start, stop, step)
+	} else {
+		scan(t.root, start, stop, step)
+	}
+	return acc
+}
+
+// FindFirst returns the smallest item within the bounds set by start
+// and stop (as with Range) that pred returns true for, and true.
+// If there is no such item, it returns a zero T and false.
+func (t *Tree[T]) FindFirst(start, stop, pred Test[T]) (item T, found bool) {
+	scan(t.view(), start, stop, func(v T) bool {
+		if pred(v) {
+			item, found = v, true
+		}
+		return !found
+	})
+	return
+}
+
+// FindLast is like FindFirst, but returns the largest matching item.
+// It searches in descending order starting from stop.
+func (t *Tree[T]) FindLast(start, stop, pred Test[T]) (item T, found bool) {
+	scanDesc(t.view(), start, stop, func(v T) bool {
+		if pred(v) {
+			item, found = v, true
+		}
+		return !found
+	})
+	return
+}
+
+// Any returns true if pred returns true for any item within the bounds
+// set by start and stop (as with Range), which may be nil.
+// It stops at the first item pred returns true for.
+func (t *Tree[T]) Any(start, stop, pred Test[T]) bool {
+	_, found := t.FindFirst(start, stop, pred)
+	return found
+}
+
+// All returns true if pred returns true for every item within the bounds
+// set by start and stop (as with Range), which may be nil.
+// It stops at the first item pred returns false for.
+func (t *Tree[T]) All(start, stop, pred Test[T]) bool {
+	return !t.Any(start, stop, func(v T) bool { return !pred(v) })
+}
+
+// None returns true if pred returns false for every item within the bounds
+// set by start and stop (as with Range), which may be nil.
+// It stops at the first item pred returns true for.
+func (t *Tree[T]) None(start, stop, pred Test[T]) bool {
+	return !t.Any(start, stop, pred)
+}
+
+// FirstGap returns the smallest value that is not in the Tree, starting at from and
+// counting upwards using succ, which must return the next value after the one it is
+// passed.  This is handy for things like finding the lowest unused ID:
+//
+//	id := tree.FirstGap(1, func(v int) int { return v + 1 })
+//
+// FirstGap only looks at the run of consecutive items starting at from.
+func (t *Tree[T]) FirstGap(from T, succ func(T) T) T {
+	res := from
+	scan(t.view(), Lt(t.Cmp(from)), nil, func(v T) bool {
+		if t.less(res, v) {
+			return false
+		}
+		res = succ(res)
+		return true
+	})
+	return res
+}
@@ -0,0 +1,166 @@
+package btree
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// loadChunk is how many records Load and LoadRows gather before sorting them.
+const loadChunk = 1 << 14
+
+// Build makes a new Tree ordered by lt that contains items.
+// items will be sorted in-place, and if there are several items that lt
+// considers to be equal only the last one in items will be kept, just
+// as if they had been passed to Insert one at a time.  Build constructs the
+// Tree directly from the sorted items, so it avoids all the
+// rebalancing work that repeated calls to Insert would do.
+func Build[T any](items []T, lt LessThan[T]) *Tree[T] {
+	res := New[T](lt)
+	res.root = res.build(sortUnique(lt, items))
+	return res
+}
+
+// chunkSorter sorts a stream of items in chunks as they arrive, and merges
+// the sorted chunks into a Tree at the end.
+type chunkSorter[T any] struct {
+	lt    LessThan[T]
+	chunk []T
+	runs  [][]T
+}
+
+func (c *chunkSorter[T]) add(item T) {
+	if c.chunk = append(c.chunk, item); len(c.chunk) == loadChunk {
+		c.runs = append(c.runs, sortUnique(c.lt, c.chunk))
+		c.chunk = nil
+	}
+}
+
+// tree merges the sorted chunks pairwise until one is left, and builds a
+// Tree from it.  Later chunks win ties, as later items do in Build.
+func (c *chunkSorter[T]) tree() *Tree[T] {
+	if len(c.chunk) > 0 {
+		c.runs = append(c.runs, sortUnique(c.lt, c.chunk))
+	}
+	runs := c.runs
+	for len(runs) > 1 {
+		next := runs[:0]
+		for i := 0; i < len(runs); i += 2 {
+			if i+1 == len(runs) {
+				next = append(next, runs[i])
+			} else {
+				next = append(next, mergeRuns(c.lt, runs[i], runs[i+1]))
+			}
+		}
+		runs = next
+	}
+	res := New[T](c.lt)
+	if len(runs) == 1 {
+		res.root = res.build(runs[0])
+	}
+	return res
+}
+
+// mergeRuns merges two sorted runs of unique items into a new one, keeping
+// the item from b when both have equal items.
+func mergeRuns[T any](lt LessThan[T], a, b []T) []T {
+	res := make([]T, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case lt(a[0], b[0]):
+			res, a = append(res, a[0]), a[1:]
+		case lt(b[0], a[0]):
+			res, b = append(res, b[0]), b[1:]
+		default:
+			res, a, b = append(res, b[0]), a[1:], b[1:]
+		}
+	}
+	res = append(res, a...)
+	return append(res, b...)
+}
+
+// Load reads newline-delimited records from r, decodes each of them with dec,
+// and returns a new Tree ordered by lt holding the decoded items.  Blank lines
+// are skipped, and trailing carriage returns are removed before dec is called.
+// dec is free to retain the slice it is passed.
+//
+// Records are sorted in chunks as they are read, which drops duplicates
+// early and leaves only a merge of the sorted chunks to do at the end.  The
+// Tree is then built directly from the merged items as Build does, which is
+// much faster than calling Insert for each record.  The sort is not an
+// external one, since the finished Tree has to hold every item in memory
+// anyway.  If dec returns an error, Load stops and returns it along with the
+// line number it failed at.
+func Load[T any](r io.Reader, dec func([]byte) (T, error), lt LessThan[T]) (*Tree[T], error) {
+	items := chunkSorter[T]{lt: lt}
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		buf, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if rec := bytes.TrimRight(buf, "\r\n"); len(rec) > 0 {
+			item, decErr := dec(rec)
+			if decErr != nil {
+				return nil, fmt.Errorf("line %d: %w", line, decErr)
+			}
+			items.add(item)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	return items.tree(), nil
+}
+
+// LoadRows scans every remaining row in rows with scan and returns a new
+// Tree ordered by lt holding the scanned items.  Like Load, the items are
+// sorted in chunks and built into a Tree rather than inserted one at a time.
+// LoadRows closes rows when it is finished with them.
+//
+// Example:
+//
+//	rows, err := db.Query("SELECT id, name FROM users")
+//	...
+//	tree, err := LoadRows(rows, func(r *sql.Rows) (u User, err error) {
+//	    err = r.Scan(&u.ID, &u.Name)
+//	    return
+//	}, func(a, b User) bool { return a.ID < b.ID })
+func LoadRows[T any](rows *sql.Rows, scan func(*sql.Rows) (T, error), lt LessThan[T]) (*Tree[T], error) {
+	defer rows.Close()
+	items := chunkSorter[T]{lt: lt}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items.add(item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items.tree(), nil
+}
+
+// sortUnique sorts items according to lt and removes all but the last
+// of any run of equal items.  Input that is already sorted is left alone.
+func sortUnique[T any](lt LessThan[T], items []T) []T {
+	if !sort.SliceIsSorted(items, func(i, j int) bool { return lt(items[i], items[j]) }) {
+		sort.SliceStable(items, func(i, j int) bool { return lt(items[i], items[j]) })
+	}
+	if len(items) < 2 {
+		return items
+	}
+	res := items[:1]
+	for _, v := range items[1:] {
+		if lt(res[len(res)-1], v) {
+			res = append(res, v)
+		} else {
+			res[len(res)-1] = v
+		}
+	}
+	return res
+}
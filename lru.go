@@ -0,0 +1,134 @@
+package btree
+
+import "container/list"
+
+type lruEntry[T any] struct {
+	item T
+	elem *list.Element
+}
+
+// LRU is an ordered cache.  It keeps its items ordered like a Tree, but it also
+// tracks how recently each item was inserted or looked up, and it evicts the
+// least recently used item whenever Insert would make it hold more than its capacity.
+type LRU[T any] struct {
+	tree     *Tree[*lruEntry[T]]
+	recency  *list.List
+	capacity int
+	onEvict  func(T)
+}
+
+// NewLRU makes a new LRU that is ordered by lt and will hold at most capacity items.
+// If onEvict is not nil, it will be called with each item that is evicted.
+func NewLRU[T any](lt LessThan[T], capacity int, onEvict func(T)) *LRU[T] {
+	if capacity < 1 {
+		panic("LRU capacity must be at least 1")
+	}
+	return &LRU[T]{
+		tree:     New[*lruEntry[T]](func(a, b *lruEntry[T]) bool { return lt(a.item, b.item) }),
+		recency:  list.New(),
+		capacity: capacity,
+		onEvict:  onEvict,
+	}
+}
+
+func lruCmp[T any](cmp CompareAgainst[T]) CompareAgainst[*lruEntry[T]] {
+	return func(e *lruEntry[T]) int { return cmp(e.item) }
+}
+
+func lruTest[T any](test Test[T]) Test[*lruEntry[T]] {
+	if test == nil {
+		return nil
+	}
+	return func(e *lruEntry[T]) bool { return test(e.item) }
+}
+
+// Len returns the number of items in the LRU.
+func (l *LRU[T]) Len() int { return l.tree.Len() }
+
+// Cap returns the maximum number of items the LRU will hold.
+func (l *LRU[T]) Cap() int { return l.capacity }
+
+// Insert adds item to the LRU, replacing any equal item, and marks it
+// as the most recently used item.  If that leaves the LRU over capacity,
+// the least recently used item is evicted.
+func (l *LRU[T]) Insert(item T) {
+	ref := &lruEntry[T]{item: item}
+	if e, found := l.tree.find(ref); found {
+		e.item = item
+		l.recency.MoveToFront(e.elem)
+		return
+	}
+	ref.elem = l.recency.PushFront(ref)
+	l.tree.Insert(ref)
+	for l.tree.Len() > l.capacity {
+		victim := l.recency.Remove(l.recency.Back()).(*lruEntry[T])
+		l.tree.Delete(victim)
+		if l.onEvict != nil {
+			l.onEvict(victim.item)
+		}
+	}
+}
+
+// Get returns the item matching cmp and true, or a zero T and false
+// if there is no such item.  A found item is marked as the most recently used.
+func (l *LRU[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
+	e, found := l.tree.Get(lruCmp(cmp))
+	if found {
+		item = e.item
+		l.recency.MoveToFront(e.elem)
+	}
+	return
+}
+
+// Peek is like Get, but it does not change how recently the item was used.
+func (l *LRU[T]) Peek(cmp CompareAgainst[T]) (item T, found bool) {
+	e, found := l.tree.Get(lruCmp(cmp))
+	if found {
+		item = e.item
+	}
+	return
+}
+
+// Has returns true if the LRU contains an item matching cmp.
+// It does not change how recently the item was used.
+func (l *LRU[T]) Has(cmp CompareAgainst[T]) bool {
+	return l.tree.Has(lruCmp(cmp))
+}
+
+// Delete removes item from the LRU, returning the item deleted and true,
+// or a zero T and false if it was not in the LRU.
+func (l *LRU[T]) Delete(item T) (deleted T, found bool) {
+	e, found := l.tree.Delete(&lruEntry[T]{item: item})
+	if found {
+		deleted = e.item
+		l.recency.Remove(e.elem)
+	}
+	return
+}
+
+// Oldest returns the least recently used item and true, or a zero T and false
+// if the LRU is empty.
+func (l *LRU[T]) Oldest() (item T, found bool) {
+	if back := l.recency.Back(); back != nil {
+		item, found = back.Value.(*lruEntry[T]).item, true
+	}
+	return
+}
+
+// Range iterates over the items in the LRU in ascending order just
+// like Tree.Range does.  It does not change how recently items were used.
+func (l *LRU[T]) Range(start, stop, iterator Test[T]) {
+	l.tree.Range(lruTest(start), lruTest(stop), lruTest(iterator))
+}
+
+// Walk calls iterator for each item in the LRU in ascending order,
+// stopping early if iterator returns false.  It does not change how recently items were used.
+func (l *LRU[T]) Walk(iterator Test[T]) {
+	l.tree.Walk(lruTest(iterator))
+}
+
+// Release releases the memory the LRU holds.  The LRU must not be used afterwards.
+func (l *LRU[T]) Release() {
+	l.tree.Release()
+	l.recency.Init()
+}
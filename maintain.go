@@ -0,0 +1,149 @@
+package btree
+
+import (
+	"sync"
+	"time"
+)
+
+// TaskStats holds statistics about one task run by a Maintainer.
+type TaskStats struct {
+	Name           string
+	Runs, Failures uint64
+	// LastErr is the error returned by the most recent failed run.
+	LastErr error
+	// Busy is the total time spent running the task.
+	Busy time.Duration
+}
+
+type maintenanceTask struct {
+	TaskStats
+	fn func() error
+}
+
+// Maintainer runs maintenance tasks, such as Rebalance on Trees that defer
+// rebalancing, TimeSeries.Expire, or FlushDirty, from a background goroutine.
+// Since Trees are not safe for concurrent use, every task is run while
+// holding the sync.Locker passed to NewMaintainer, which must be the same
+// lock that guards the Trees the tasks use.
+//
+// Example:
+//
+//	var mu sync.Mutex
+//	m := NewMaintainer(&mu, time.Minute)
+//	m.Add("rebalance", func() error {
+//		tree.Rebalance()
+//		return nil
+//	})
+//	m.Start()
+//	defer m.Stop()
+type Maintainer struct {
+	lock     sync.Locker
+	interval time.Duration
+	mu       sync.Mutex
+	tasks    []*maintenanceTask
+	stop     chan struct{}
+	done     chan struct{}
+	clock    Clock
+}
+
+// NewMaintainer makes a Maintainer that will run its tasks every interval
+// once Start is called.  Tasks are run while holding lock.  interval only
+// has to be positive if Start is called.
+func NewMaintainer(lock sync.Locker, interval time.Duration) *Maintainer {
+	return &Maintainer{lock: lock, interval: interval}
+}
+
+// SetClock makes the Maintainer use c to measure how long tasks take.
+// The Maintainer still waits for real time to pass between runs once Start
+// is called, so tests that use a Clock they control should call RunOnce instead.
+// Passing nil goes back to SystemClock.
+func (m *Maintainer) SetClock(c Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+}
+
+// Add adds a task to the Maintainer.  Tasks are run in the order they were added.
+func (m *Maintainer) Add(name string, fn func() error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tasks = append(m.tasks, &maintenanceTask{TaskStats: TaskStats{Name: name}, fn: fn})
+}
+
+// Start starts running tasks in the background.  Calling Start on a
+// Maintainer that is already running does nothing.  Start panics if the
+// Maintainer's interval is not positive.
+func (m *Maintainer) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	if m.interval <= 0 {
+		panic("Maintainer interval must be positive")
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		tick := time.NewTicker(m.interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+				m.RunOnce()
+			}
+		}
+	}()
+	m.stop, m.done = stop, done
+}
+
+// Stop stops running tasks in the background, waiting for any tasks
+// that are running to finish first.  Stop must not be called by a task.
+func (m *Maintainer) Stop() {
+	m.mu.Lock()
+	stop, done := m.stop, m.done
+	m.stop, m.done = nil, nil
+	m.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// RunOnce runs every task once right away.
+func (m *Maintainer) RunOnce() {
+	m.mu.Lock()
+	tasks, clock := m.tasks, m.clock
+	m.mu.Unlock()
+	if clock == nil {
+		clock = SystemClock
+	}
+	for _, task := range tasks {
+		m.lock.Lock()
+		start := clock.Now()
+		err := task.fn()
+		busy := clock.Now().Sub(start)
+		m.lock.Unlock()
+		m.mu.Lock()
+		task.Runs++
+		task.Busy += busy
+		if err != nil {
+			task.Failures++
+			task.LastErr = err
+		}
+		m.mu.Unlock()
+	}
+}
+
+// Stats returns statistics for each task, in the order they were added.
+func (m *Maintainer) Stats() []TaskStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]TaskStats, len(m.tasks))
+	for i, task := range m.tasks {
+		res[i] = task.TaskStats
+	}
+	return res
+}
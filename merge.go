@@ -0,0 +1,451 @@
+package btree
+
+// cursor wraps an Iterator for walking several Trees in step with each other.
+type cursor[T any] struct {
+	iter *Iterator[T]
+	ok   bool
+}
+
+func (t *Tree[T]) cursor() *cursor[T] {
+	return t.cursorIn(nil, nil)
+}
+
+// cursorIn is cursor for the items between start and stop.
+func (t *Tree[T]) cursorIn(start, stop Test[T]) *cursor[T] {
+	c := &cursor[T]{iter: t.Iterator(start, stop)}
+	c.ok = c.iter.Next()
+	return c
+}
+
+func (c *cursor[T]) item() T { return c.iter.Item() }
+
+func (c *cursor[T]) next() { c.ok = c.iter.Next() }
+
+// at returns true if the cursor is positioned on an item equal to key,
+// which must not be greater than the item the cursor is positioned on.
+func (c *cursor[T]) at(lt LessThan[T], key T) bool {
+	return c.ok && !lt(key, c.item())
+}
+
+// compatibleSample is how many levels of each Tree CheckCompatible looks at.
+const compatibleSample = 3
+
+// CheckCompatible returns ErrIncompatibleOrdering if t and other are obviously
+// not ordered the same way, which would make Union, Except, Merge3, and the
+// other operations that walk Trees in step return wrong results.
+// Trees with different Comparator names are incompatible.  Otherwise,
+// CheckCompatible takes the items near the top of each Tree, which the
+// Tree holds in order, and checks that the other Tree does not order any
+// neighbouring pair of them the other way around.  It takes O(1) time, so it
+// catches reversed and unrelated orderings, not subtly different ones.
+func (t *Tree[T]) CheckCompatible(other *Tree[T]) error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	if err := other.init(); err != nil {
+		return err
+	}
+	if t.comparator != "" && other.comparator != "" && t.comparator != other.comparator {
+		return ErrIncompatibleOrdering
+	}
+	if !t.ordersLike(other) || !other.ordersLike(t) {
+		return ErrIncompatibleOrdering
+	}
+	return nil
+}
+
+// ordersLike returns false if other puts any neighbouring pair of the items
+// in the top few levels of t, or in t.small, in the opposite order.
+func (t *Tree[T]) ordersLike(other *Tree[T]) bool {
+	for i := 1; i < len(t.small); i++ {
+		if other.less(t.small[i], t.small[i-1]) {
+			return false
+		}
+	}
+	var prev T
+	_, _, ok := sampleOrder(t.root, 0, other.less, prev, false)
+	return ok
+}
+
+// sampleOrder walks the items in the top few levels under h in order, and
+// returns false if lt puts any of them before the one visited just before it.
+// It also returns the last item it visited, so it allocates nothing.
+func sampleOrder[T any](h *node[T], depth int, lt LessThan[T], prev T, seen bool) (T, bool, bool) {
+	if h == nil || depth == compatibleSample {
+		return prev, seen, true
+	}
+	prev, seen, ok := sampleOrder(h.l, depth+1, lt, prev, seen)
+	if !ok || (seen && lt(h.i, prev)) {
+		return prev, seen, false
+	}
+	return sampleOrder(h.r, depth+1, lt, h.i, true)
+}
+
+// keepsKey panics if v, an item returned by a resolve function, is not equal
+// to key, since building a Tree from it would leave the Tree out of order.
+func keepsKey[T any](lt LessThan[T], v, key T) T {
+	if lt(v, key) || lt(key, v) {
+		panic("resolve returned an item that is not equal to the ones it was passed")
+	}
+	return v
+}
+
+// Conflict describes an item that Merge3 could not merge on its own.
+// The In fields indicate which Trees held the item.
+type Conflict[T any] struct {
+	Base, Mine, Theirs       T
+	InBase, InMine, InTheirs bool
+}
+
+// Merge3 performs a three-way merge of mine and theirs, which were both
+// derived from base, and returns a new Tree holding the result along with
+// a list of items that could not be merged.  All three Trees must share the same
+// ordering. For each item in any of the Trees:
+//
+// * If mine and theirs both have it, resolve is called with the items from
+// base (or a zero T if base does not have it), mine, and theirs.  If resolve
+// returns true, the item it returns is added to the result.  Otherwise,
+// it is listed as a Conflict.  The item resolve returns must be equal to
+// the ones it was passed, and Merge3 panics if it is not.
+//
+// * If only one of mine or theirs has it and base does not, it was added
+// on that side and is added to the result.
+//
+// * If only one of mine or theirs has it and base does too, one side deleted it
+// and Merge3 cannot tell whether the other side changed it.  It is listed
+// as a Conflict and left out of the result.
+//
+// * If only base has it, it was deleted on both sides and is left out of the result.
+//
+// Merge3 walks all three Trees in step, and does not modify any of them.
+// It does not check that the Trees are ordered the same way.  Use Merge3E
+// for that.
+func Merge3[T any](base, mine, theirs *Tree[T], resolve func(base, mine, theirs T) (T, bool)) (*Tree[T], []Conflict[T]) {
+	mine.mustInit()
+	lt := mine.less
+	var items []T
+	var conflicts []Conflict[T]
+	bc, mc, tc := base.cursor(), mine.cursor(), theirs.cursor()
+	for bc.ok || mc.ok || tc.ok {
+		var key T
+		found := false
+		for _, c := range []*cursor[T]{bc, mc, tc} {
+			if c.ok && (!found || lt(c.item(), key)) {
+				key, found = c.item(), true
+			}
+		}
+		var c Conflict[T]
+		if c.InBase = bc.at(lt, key); c.InBase {
+			c.Base = bc.item()
+			bc.next()
+		}
+		if c.InMine = mc.at(lt, key); c.InMine {
+			c.Mine = mc.item()
+			mc.next()
+		}
+		if c.InTheirs = tc.at(lt, key); c.InTheirs {
+			c.Theirs = tc.item()
+			tc.next()
+		}
+		switch {
+		case c.InMine && c.InTheirs:
+			if v, ok := resolve(c.Base, c.Mine, c.Theirs); ok {
+				items = append(items, keepsKey(lt, v, key))
+			} else {
+				conflicts = append(conflicts, c)
+			}
+		case c.InBase && (c.InMine || c.InTheirs):
+			conflicts = append(conflicts, c)
+		case c.InMine:
+			items = append(items, c.Mine)
+		case c.InTheirs:
+			items = append(items, c.Theirs)
+		}
+	}
+	res := mine.Copy()
+	res.root = res.build(items)
+	return res, conflicts
+}
+
+// Merge3E is like Merge3, but first returns ErrIncompatibleOrdering if
+// CheckCompatible finds that the Trees are not ordered the same way.
+func Merge3E[T any](base, mine, theirs *Tree[T], resolve func(base, mine, theirs T) (T, bool)) (*Tree[T], []Conflict[T], error) {
+	if err := mine.CheckCompatible(base); err != nil {
+		return nil, nil, err
+	}
+	if err := mine.CheckCompatible(theirs); err != nil {
+		return nil, nil, err
+	}
+	res, conflicts := Merge3(base, mine, theirs, resolve)
+	return res, conflicts, nil
+}
+
+// Union returns a new Tree holding all the items in t and other, which must
+// share the same ordering.  When both Trees hold equal items, resolve is called
+// with the item from t and the item from other, and the item it returns is
+// added to the new Tree.  If resolve is nil, the item from t is used.
+// The item resolve returns must be equal to the ones it was passed, and
+// Union panics if it is not.  Union does not check that the Trees are ordered the same way.  Use UnionE
+// for that.
+func (t *Tree[T]) Union(other *Tree[T], resolve func(a, b T) T) *Tree[T] {
+	t.mustInit()
+	lt := t.less
+	var items []T
+	ac, bc := t.cursor(), other.cursor()
+	for ac.ok || bc.ok {
+		switch {
+		case !bc.ok || (ac.ok && lt(ac.item(), bc.item())):
+			items = append(items, ac.item())
+			ac.next()
+		case !ac.ok || lt(bc.item(), ac.item()):
+			items = append(items, bc.item())
+			bc.next()
+		default:
+			if resolve == nil {
+				items = append(items, ac.item())
+			} else {
+				items = append(items, keepsKey(lt, resolve(ac.item(), bc.item()), ac.item()))
+			}
+			ac.next()
+			bc.next()
+		}
+	}
+	res := t.Copy()
+	res.root = res.build(items)
+	return res
+}
+
+// UnionE is like Union, but first returns ErrIncompatibleOrdering if
+// CheckCompatible fails.
+func (t *Tree[T]) UnionE(other *Tree[T], resolve func(a, b T) T) (*Tree[T], error) {
+	if err := t.CheckCompatible(other); err != nil {
+		return nil, err
+	}
+	return t.Union(other, resolve), nil
+}
+
+// Intersection returns a new Tree holding the items that are in both t and
+// other, which must share the same ordering.  resolve is called with the
+// item from t and the item from other, and the item it returns is added to
+// the new Tree.  If resolve is nil, the item from t is used.
+// As with Union, the item resolve returns must be equal to the ones it was
+// passed, and Intersection panics if it is not.
+// Like Union, Intersection does not check that the Trees are ordered the
+// same way.  Use IntersectionE for that.
+func (t *Tree[T]) Intersection(other *Tree[T], resolve func(a, b T) T) *Tree[T] {
+	t.mustInit()
+	lt := t.less
+	var items []T
+	ac, bc := t.cursor(), other.cursor()
+	for ac.ok && bc.ok {
+		switch {
+		case lt(ac.item(), bc.item()):
+			ac.next()
+		case lt(bc.item(), ac.item()):
+			bc.next()
+		default:
+			if resolve == nil {
+				items = append(items, ac.item())
+			} else {
+				items = append(items, keepsKey(lt, resolve(ac.item(), bc.item()), ac.item()))
+			}
+			ac.next()
+			bc.next()
+		}
+	}
+	ac.iter.Release()
+	bc.iter.Release()
+	res := t.Copy()
+	res.root = res.build(items)
+	return res
+}
+
+// IntersectionE is like Intersection, but first returns
+// ErrIncompatibleOrdering if CheckCompatible fails.
+func (t *Tree[T]) IntersectionE(other *Tree[T], resolve func(a, b T) T) (*Tree[T], error) {
+	if err := t.CheckCompatible(other); err != nil {
+		return nil, err
+	}
+	return t.Intersection(other, resolve), nil
+}
+
+// Merge adds every item in other to t, and then empties other.  When both
+// Trees hold equal items, onConflict is called with the item from t and the
+// item from other, and the item it returns, which must be equal to both, is
+// kept.  If onConflict is nil, the item from other replaces the one in t, as
+// Insert would.  When other is small compared to t its items are inserted one
+// at a time.  Otherwise, the items of both Trees are merged into new nodes
+// in a single O(n+m) pass, and the nodes of other are freed.
+// Either way, the items go through the same checks Insert makes, and the
+// write gate, if there is one, is called once for the whole merge.
+// Merge ignores any error the gate returns, and panics with any other
+// error MergeE would return.
+func (t *Tree[T]) Merge(other *Tree[T], onConflict func(a, b T) T) {
+	if err := t.mergeTree(other, onConflict, false); err != nil {
+		panic(err)
+	}
+}
+
+// MergeE is like Merge, but returns an error without changing either
+// Tree if CheckCompatible fails, if any item in other fails t's domain
+// check (see SetDomain), or if the write gate rejects the merge.
+func (t *Tree[T]) MergeE(other *Tree[T], onConflict func(a, b T) T) error {
+	return t.mergeTree(other, onConflict, true)
+}
+
+// mergeTree is Merge and MergeE.  Errors from the write gate are only
+// returned if gated is true.
+func (t *Tree[T]) mergeTree(other *Tree[T], onConflict func(a, b T) T, gated bool) error {
+	if other == t || other.count == 0 {
+		return nil
+	}
+	if err := t.CheckCompatible(other); err != nil {
+		return err
+	}
+	if err := t.domainOf(other); err != nil {
+		return err
+	}
+	if err := t.callGate(); gated && err != nil {
+		return err
+	}
+	other.grow()
+	if onConflict != nil && t.count != 0 {
+		lt := t.less
+		ac := t.cursor()
+		iter := other.Iterator(nil, nil)
+		for iter.Next() {
+			n := iter.workingNode
+			for ac.ok && lt(ac.item(), n.i) {
+				ac.next()
+			}
+			if ac.at(lt, n.i) {
+				n.i = keepsKey(lt, onConflict(ac.item(), n.i), n.i)
+			}
+		}
+		ac.iter.Release()
+	}
+	dels := t.Copy()
+	err := t.merge(other, dels)
+	dels.Release()
+	other.releaseNodes(other.root)
+	other.root = nil
+	return err
+}
+
+// ExceptIterator iterates over the items in one Tree that are
+// not in another, in ascending order.  Neither Tree may be
+// modified while iterating.
+type ExceptIterator[T any] struct {
+	a, b *cursor[T]
+	lt   LessThan[T]
+	item T
+}
+
+// ExceptIterator creates an ExceptIterator that will return all the items
+// in t that are not in other.  t and other must share the same ordering.
+// As with Iterator, you must call Next to fetch the first item.
+// ExceptIterator does not check that the Trees are ordered the same way.
+func (t *Tree[T]) ExceptIterator(other *Tree[T]) *ExceptIterator[T] {
+	t.mustInit()
+	return &ExceptIterator[T]{
+		a:  &cursor[T]{iter: t.Iterator(nil, nil)},
+		b:  other.cursor(),
+		lt: t.less,
+	}
+}
+
+// Next advances to the next item that is in the first Tree but not the second,
+// returning false if there are no more such items.
+func (e *ExceptIterator[T]) Next() bool {
+	for e.a.ok = e.a.iter.Next(); e.a.ok; e.a.next() {
+		v := e.a.item()
+		for e.b.ok && e.lt(e.b.item(), v) {
+			e.b.next()
+		}
+		if !e.b.at(e.lt, v) {
+			e.item = v
+			return true
+		}
+		e.b.next()
+	}
+	return false
+}
+
+// Item returns the current item.
+func (e *ExceptIterator[T]) Item() T { return e.item }
+
+// Release releases the state the ExceptIterator holds.
+func (e *ExceptIterator[T]) Release() {
+	e.a.iter.Release()
+	e.b.iter.Release()
+	e.a.ok, e.b.ok = false, false
+}
+
+// Except returns a new Tree holding the items in t that are not in other,
+// which is the set difference of t and other.
+// t and other must share the same ordering.  Except does not check that
+// they do.  Use ExceptE for that.
+func (t *Tree[T]) Except(other *Tree[T]) *Tree[T] {
+	var items []T
+	e := t.ExceptIterator(other)
+	for e.Next() {
+		items = append(items, e.Item())
+	}
+	res := t.Copy()
+	res.root = res.build(items)
+	return res
+}
+
+// ExceptE is like Except, but first returns ErrIncompatibleOrdering if
+// CheckCompatible fails.
+func (t *Tree[T]) ExceptE(other *Tree[T]) (*Tree[T], error) {
+	if err := t.CheckCompatible(other); err != nil {
+		return nil, err
+	}
+	return t.Except(other), nil
+}
+
+// ContainsAll returns true if every item in other is also in t.
+// t and other must share the same ordering.  ContainsAll walks both
+// Trees in step and stops at the first item in other that t does not have.
+func (t *Tree[T]) ContainsAll(other *Tree[T]) bool {
+	t.mustInit()
+	if other.count > t.count {
+		return false
+	}
+	lt := t.less
+	ac, bc := t.cursor(), other.cursor()
+	defer ac.iter.Release()
+	defer bc.iter.Release()
+	for ; bc.ok; bc.next() {
+		for ac.ok && lt(ac.item(), bc.item()) {
+			ac.next()
+		}
+		if !ac.at(lt, bc.item()) {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny returns true if t and other have any items in common.
+// t and other must share the same ordering.  ContainsAny walks both
+// Trees in step and stops at the first item they have in common.
+func (t *Tree[T]) ContainsAny(other *Tree[T]) bool {
+	t.mustInit()
+	lt := t.less
+	ac, bc := t.cursor(), other.cursor()
+	defer ac.iter.Release()
+	defer bc.iter.Release()
+	for ac.ok && bc.ok {
+		switch {
+		case lt(ac.item(), bc.item()):
+			ac.next()
+		case lt(bc.item(), ac.item()):
+			bc.next()
+		default:
+			return true
+		}
+	}
+	return false
+}
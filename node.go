@@ -1,14 +1,26 @@
 package btree
 
+import (
+	"math"
+	"math/bits"
+)
+
 // node[T] is a generic type that represents a node in the AVL tree.
+// The subtree size costs a word for items of 4 bytes or less, which the
+// height alone would have packed in next to, but shares a word with the
+// height for larger items.
 type node[T any] struct {
 	p *node[T] // parent
 	l *node[T] // left child
 	r *node[T] // right child
-	h uint     // height of the node.
+	h uint8    // height of the node. AVL trees never get anywhere near 255 high.
+	s uint32   // number of items in the subtree rooted at the node.
 	i T        // The item the node is holding.
 }
 
+// maxItems is the most items a Tree can hold, since node.s has to count them.
+const maxItems = math.MaxUint32
+
 // balance calculates the relative balance of a node.
 // Negative numbers indicate a subtree that is left-heavy,
 // and positive numbers indicate a tree that is right-heavy.
@@ -22,7 +34,7 @@ func (n *node[T]) balance() (res int) {
 	return
 }
 
-// setHeight calculates the height of this node.
+// setHeight calculates the height and size of this node.
 func (n *node[T]) setHeight() {
 	n.h = 0
 	if n.l != nil {
@@ -32,14 +44,41 @@ func (n *node[T]) setHeight() {
 		n.h = n.r.h
 	}
 	n.h++
+	n.s = uint32(1 + n.l.size() + n.r.size())
 	return
 }
 
+// size returns the number of items in the subtree rooted at n, which may be nil.
+func (n *node[T]) size() int {
+	if n == nil {
+		return 0
+	}
+	return int(n.s)
+}
+
+// fixSizes recalculates the sizes of n and all its ancestors.  Insert and
+// remove call it after rebalancing, since rebalanceAt stops as soon as
+// heights stop changing.
+func fixSizes[T any](n *node[T]) {
+	for ; n != nil; n = n.p {
+		n.s = uint32(1 + n.l.size() + n.r.size())
+	}
+}
+
 func (t *Tree[T]) newNode(v T) *node[T] {
+	if uint64(t.count) >= maxItems {
+		panic("Tree cannot hold more than 4294967295 items")
+	}
 	res := t.nodePool.Get().(*node[T])
 	res.i = v
 	res.h = 1
+	res.s = 1
+	if t.quota != nil {
+		t.quota.items++
+		t.quota.bytes += t.quota.size(v)
+	}
 	t.count++
+	t.version++
 	t.insertCount++
 	return res
 }
@@ -48,10 +87,19 @@ func (t *Tree[T]) putNode(n *node[T]) {
 	n.l = nil
 	n.r = nil
 	n.p = nil
+	if t.quota != nil {
+		t.quota.items--
+		t.quota.bytes -= t.quota.size(n.i)
+	}
 	var ref T
 	n.i = ref
 	n.h = 0
+	n.s = 0
+	if t.access != nil {
+		delete(t.access.hits, n)
+	}
 	t.count--
+	t.version++
 	t.removeCount++
 	t.nodePool.Put(n)
 }
@@ -62,6 +110,7 @@ func (t *Tree[T]) copyNodes(n *node[T], into *Tree[T]) *node[T] {
 	}
 	res := into.newNode(n.i)
 	res.h = n.h
+	res.s = n.s
 	if res.l = t.copyNodes(n.l, into); res.l != nil {
 		res.l.p = res
 	}
@@ -71,6 +120,41 @@ func (t *Tree[T]) copyNodes(n *node[T], into *Tree[T]) *node[T] {
 	return res
 }
 
+// build makes a perfectly balanced subtree out of items, which must
+// already be sorted and free of duplicates.
+func (t *Tree[T]) build(items []T) *node[T] {
+	if len(items) == 0 {
+		return nil
+	}
+	mid := len(items) / 2
+	res := t.newNode(items[mid])
+	if res.l = t.build(items[:mid]); res.l != nil {
+		res.l.p = res
+	}
+	if res.r = t.build(items[mid+1:]); res.r != nil {
+		res.r.p = res
+	}
+	res.setHeight()
+	return res
+}
+
+// relink arranges nodes, which must be in order, into a perfectly balanced subtree.
+func relink[T any](nodes []*node[T]) *node[T] {
+	if len(nodes) == 0 {
+		return nil
+	}
+	mid := len(nodes) / 2
+	res := nodes[mid]
+	if res.l = relink(nodes[:mid]); res.l != nil {
+		res.l.p = res
+	}
+	if res.r = relink(nodes[mid+1:]); res.r != nil {
+		res.r.p = res
+	}
+	res.setHeight()
+	return res
+}
+
 func (t *Tree[T]) releaseNodes(n *node[T]) {
 	var s *node[T]
 	for n != nil {
@@ -167,7 +251,14 @@ func (a *node[T]) rotateRight() (b *node[T]) {
 }
 
 func (t *Tree[T]) getExact(n *node[T], v T) (res *node[T], dir int) {
-	for n != nil {
+	if t.cmp != nil {
+		return t.getExactCmp(n, v)
+	}
+	limit := t.depthLimit()
+	for depth := 0; n != nil; depth++ {
+		if depth == limit {
+			t.tooDeep(limit)
+		}
 		if t.less(v, n.i) {
 			if n.l == nil {
 				return n, Less
@@ -185,6 +276,31 @@ func (t *Tree[T]) getExact(n *node[T], v T) (res *node[T], dir int) {
 	return n, Equal
 }
 
+// getExactCmp is getExact for trees that have a three-way comparator.
+func (t *Tree[T]) getExactCmp(n *node[T], v T) (res *node[T], dir int) {
+	limit := t.depthLimit()
+	for depth := 0; n != nil; depth++ {
+		if depth == limit {
+			t.tooDeep(limit)
+		}
+		switch c := t.cmp(v, n.i); {
+		case c < 0:
+			if n.l == nil {
+				return n, Less
+			}
+			n = n.l
+		case c > 0:
+			if n.r == nil {
+				return n, Greater
+			}
+			n = n.r
+		default:
+			return n, Equal
+		}
+	}
+	return n, Equal
+}
+
 // min finds the minimal child of h
 func min[T any](n *node[T]) *node[T] {
 	for n.l != nil {
@@ -201,11 +317,29 @@ func max[T any](n *node[T]) *node[T] {
 	return n
 }
 
+// fixHeights walks up the tree starting at node n, recalculating node heights
+// without performing any rotations. It is used in place of rebalanceAt
+// while rebalancing is deferred.
+func (t *Tree[T]) fixHeights(n *node[T]) {
+	for n != nil {
+		oh := n.h
+		n.setHeight()
+		if oh == n.h {
+			return
+		}
+		n = n.p
+	}
+}
+
 // rebalanceAt walks up the tree starting at node n, rebalancing nodes
 // that no longer meet the AVL balance criteria. rebalanceAt will continue until
 // it either walks all the way up the tree, or the node has the
 // same height it started with.
 func (t *Tree[T]) rebalanceAt(n *node[T], forInsert bool) {
+	if t.deferred {
+		t.fixHeights(n)
+		return
+	}
 	for {
 		oh := n.h
 		switch n.balance() {
@@ -260,18 +394,23 @@ func (t *Tree[T]) rebalanceAt(n *node[T], forInsert bool) {
 func (t *Tree[T]) insert(v T) {
 	n, direction := t.getExact(t.root, v)
 	var needRebalance bool
+	var leaf *node[T]
 	switch direction {
 	case Equal:
+		if t.quota != nil {
+			t.quota.bytes += t.quota.size(v) - t.quota.size(n.i)
+		}
 		n.i = v
+		t.version++
 		return
 	case Less:
 		n.l = t.newNode(v)
 		n.l.p = n
-		needRebalance = n.r == nil
+		leaf, needRebalance = n.l, n.r == nil
 	case Greater:
 		n.r = t.newNode(v)
 		n.r.p = n
-		needRebalance = n.l == nil
+		leaf, needRebalance = n.r, n.l == nil
 	}
 	if needRebalance {
 		n.h++
@@ -279,10 +418,24 @@ func (t *Tree[T]) insert(v T) {
 			t.rebalanceAt(n.p, true)
 		}
 	}
+	fixSizes(leaf.p)
+	if t.deferred && t.root.h > maxDeferredHeight(t.count) {
+		t.Rebalance()
+	}
+}
+
+// maxDeferredHeight is how tall a tree holding count items may get while
+// rebalancing is deferred. It is high enough that random inserts
+// will almost never reach it, and low enough to always fit in node.h.
+func maxDeferredHeight(count int) uint8 {
+	return 4 * uint8(bits.Len(uint(count)))
 }
 
 // remove the passed-in value from the tree, if it exists. The tree will be rebalanced if needed.
 func (t *Tree[T]) remove(v T) (deleted T, found bool) {
+	if t.small != nil {
+		return t.removeSmall(v)
+	}
 	at, direction := t.getExact(t.root, v)
 	if found = direction == Equal; !found {
 		return
@@ -294,6 +447,7 @@ func (t *Tree[T]) remove(v T) (deleted T, found bool) {
 			if alt = at.p; alt != nil {
 				alt.swapChild(at, at.r)
 				t.rebalanceAt(alt, false)
+				fixSizes(alt)
 			} else {
 				t.root = nil
 			}
@@ -307,6 +461,9 @@ func (t *Tree[T]) remove(v T) (deleted T, found bool) {
 			panic("Impossible")
 		}
 		at.i, alt.i = alt.i, at.i
+		if t.access != nil {
+			t.access.swap(at, alt)
+		}
 		at = alt
 	}
 }
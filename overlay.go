@@ -0,0 +1,331 @@
+package btree
+
+import "math/bits"
+
+// overlayLayer holds the items inserted into one layer of an Overlay, and
+// tombstones for items it deleted from the layers below it.  An item is
+// never in both.  The bottom layer never has tombstones.
+type overlayLayer[T any] struct {
+	puts, dels *Tree[T]
+}
+
+// Overlay is a stack of layers over a base Tree.  Writes go to the top layer,
+// and reads look through the layers from the top down: an item inserted into
+// a layer hides equal items in the layers below it, and deleting an item
+// that is visible from below leaves a tombstone that hides it.  This lets a
+// burst of writes be kept on the side and merged into the base later.
+// The base Tree must not be modified except through the Overlay while the Overlay is in use.
+// Like Tree, Overlay is not safe for concurrent use.
+type Overlay[T any] struct {
+	layers []overlayLayer[T]
+}
+
+// NewOverlay makes a new Overlay with base as its only layer.
+// Call Push to add a layer for writes to go to.
+func NewOverlay[T any](base *Tree[T]) *Overlay[T] {
+	base.mustInit()
+	return &Overlay[T]{layers: []overlayLayer[T]{{puts: base}}}
+}
+
+// Base returns the Tree at the bottom of the Overlay.
+func (o *Overlay[T]) Base() *Tree[T] { return o.layers[0].puts }
+
+// Layers returns the number of layers in the Overlay, including the base.
+func (o *Overlay[T]) Layers() int { return len(o.layers) }
+
+func (o *Overlay[T]) top() overlayLayer[T] { return o.layers[len(o.layers)-1] }
+
+// Push adds a new empty layer to the top of the Overlay.
+func (o *Overlay[T]) Push() {
+	base := o.Base()
+	o.layers = append(o.layers, overlayLayer[T]{puts: base.Copy(), dels: base.Copy()})
+}
+
+// Pop throws away the top layer of the Overlay along with all the changes
+// it holds.  Pop will not remove the base, and returns false if there
+// is only the base left.
+func (o *Overlay[T]) Pop() bool {
+	if len(o.layers) == 1 {
+		return false
+	}
+	top := o.top()
+	top.puts.Release()
+	top.dels.Release()
+	o.layers = o.layers[:len(o.layers)-1]
+	return true
+}
+
+// Get returns the item matching cmp from the highest layer that has
+// either the item or a tombstone for it.
+func (o *Overlay[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
+	for i := len(o.layers) - 1; i >= 0; i-- {
+		l := o.layers[i]
+		if item, found = l.puts.Get(cmp); found || (l.dels != nil && l.dels.Has(cmp)) {
+			return
+		}
+	}
+	return
+}
+
+// Has returns true if an item matching cmp is visible through the Overlay.
+func (o *Overlay[T]) Has(cmp CompareAgainst[T]) bool {
+	_, found := o.Get(cmp)
+	return found
+}
+
+// below returns the item equal to v that is visible from under the top layer.
+func (o *Overlay[T]) below(v T) (item T, found bool) {
+	for i := len(o.layers) - 2; i >= 0; i-- {
+		l := o.layers[i]
+		if item, found = l.puts.find(v); found {
+			return
+		}
+		if l.dels != nil {
+			if _, deleted := l.dels.find(v); deleted {
+				return
+			}
+		}
+	}
+	return
+}
+
+// Insert adds or replaces item in the top layer.  If the base has a write
+// gate, Insert waits for it, but ignores any error it returns.
+func (o *Overlay[T]) Insert(item T) {
+	top := o.top()
+	if len(o.layers) > 1 {
+		o.Base().callGate()
+	}
+	if top.dels != nil {
+		top.dels.Delete(item)
+	}
+	top.puts.Insert(item)
+}
+
+// Delete removes item from view, returning the item that was visible
+// and true, or a zero T and false if there was no such item.
+func (o *Overlay[T]) Delete(item T) (deleted T, found bool) {
+	top := o.top()
+	deleted, found = top.puts.Delete(item)
+	if top.dels == nil {
+		return
+	}
+	if v, ok := o.below(item); ok {
+		if _, dup := top.dels.find(item); !dup {
+			if !found {
+				deleted, found = v, true
+			}
+			top.dels.Insert(v)
+		}
+	}
+	return
+}
+
+// Range iterates in ascending order over the items visible through the
+// Overlay that are between start and stop, as with Tree.Range.
+// The layers are merged together as they are iterated over.
+func (o *Overlay[T]) Range(start, stop, iterator Test[T]) {
+	lt := o.Base().less
+	puts := make([]*cursor[T], len(o.layers))
+	dels := make([]*cursor[T], len(o.layers))
+	for i, l := range o.layers {
+		puts[i] = l.puts.cursorIn(start, stop)
+		if l.dels != nil {
+			dels[i] = l.dels.cursorIn(start, stop)
+		}
+	}
+	defer func() {
+		for i := range puts {
+			puts[i].iter.Release()
+			if dels[i] != nil {
+				dels[i].iter.Release()
+			}
+		}
+	}()
+	for {
+		var key T
+		found := false
+		for _, c := range puts {
+			if c.ok && (!found || lt(c.item(), key)) {
+				key, found = c.item(), true
+			}
+		}
+		if !found {
+			return
+		}
+		visible, decided := false, false
+		for i := len(o.layers) - 1; i >= 0; i-- {
+			if d := dels[i]; d != nil {
+				for d.ok && lt(d.item(), key) {
+					d.next()
+				}
+				if d.at(lt, key) {
+					decided = true
+					d.next()
+				}
+			}
+			if p := puts[i]; p.at(lt, key) {
+				if !decided {
+					key, visible, decided = p.item(), true, true
+				}
+				p.next()
+			}
+		}
+		if visible && !iterator(key) {
+			return
+		}
+	}
+}
+
+// Walk calls iterator for every item visible through the Overlay in ascending order.
+func (o *Overlay[T]) Walk(iterator Test[T]) {
+	o.Range(nil, nil, iterator)
+}
+
+// Len returns the number of items visible through the Overlay.
+// If there is more than one layer, Len has to walk all of them.
+func (o *Overlay[T]) Len() (res int) {
+	if len(o.layers) == 1 {
+		return o.Base().count
+	}
+	o.Walk(func(T) bool {
+		res++
+		return true
+	})
+	return
+}
+
+// CompactStep merges the lowest layer above the base into the base, and
+// returns false if there was nothing to merge.  Higher layers keep working
+// as before, so CompactStep can be called by a Maintainer to compact an
+// Overlay a layer at a time while writes keep going to the top layer.
+// The base sees the changes as if they were made with Insert and Delete,
+// so its Flusher and recycle bin see them as well.  If an item in the layer
+// fails the base's domain check, CompactStep panics with the error before
+// changing anything.
+func (o *Overlay[T]) CompactStep() bool {
+	if len(o.layers) == 1 {
+		return false
+	}
+	l := o.layers[1]
+	o.Base().callGate()
+	if err := o.Base().merge(l.puts, l.dels); err != nil {
+		panic(err)
+	}
+	l.puts.Release()
+	l.dels.Release()
+	o.layers = append(o.layers[:1], o.layers[2:]...)
+	return true
+}
+
+// Compact merges every layer into the base, leaving the base as the only layer.
+// If progress is not nil, it is called after each layer is merged with the number of
+// layers merged so far and the number there were to merge.
+func (o *Overlay[T]) Compact(progress func(merged, total int)) {
+	total := len(o.layers) - 1
+	for merged := 1; o.CompactStep(); merged++ {
+		if progress != nil {
+			progress(merged, total)
+		}
+	}
+}
+
+// Release throws away every layer except the base.  The Overlay must not be used afterwards.
+func (o *Overlay[T]) Release() {
+	for o.Pop() {
+	}
+}
+
+// merge deletes the items in dels from t and inserts the items in puts.
+// When there are only a few changes they are made one at a time.  Otherwise
+// the nodes of t are merged with the changes in a single pass and relinked
+// into a balanced tree, which takes O(n) time no matter how many changes there are.
+// Both ways apply the same checks Insert does, apart from the write gate,
+// which callers call once for the whole merge.  The domain check is run on
+// every item in puts first, and its error is returned before t is changed,
+// so t is never left half merged.  Trees with a Quota always make changes
+// one at a time, since admitting an item can evict others from the middle of t.
+func (t *Tree[T]) merge(puts, dels *Tree[T]) error {
+	changes := puts.count + dels.count
+	if changes == 0 {
+		return nil
+	}
+	if err := t.domainOf(puts); err != nil {
+		return err
+	}
+	if t.grow() == nil || t.quota != nil || changes*bits.Len(uint(t.count)) < t.count {
+		scan(dels.view(), nil, nil, func(v T) bool {
+			t.Delete(v)
+			return true
+		})
+		scan(puts.view(), nil, nil, func(v T) bool {
+			t.add(v)
+			return true
+		})
+		return nil
+	}
+	old := make([]*node[T], 0, t.count)
+	iter := t.Iterator(nil, nil)
+	for iter.Next() {
+		old = append(old, iter.workingNode)
+	}
+	var putItems, delItems []T
+	scan(puts.view(), nil, nil, func(v T) bool { putItems = append(putItems, v); return true })
+	scan(dels.view(), nil, nil, func(v T) bool { delItems = append(delItems, v); return true })
+	nodes := make([]*node[T], 0, len(old)+len(putItems))
+	now := t.now()
+	ops := len(putItems)
+	for i, p, d := 0, 0, 0; i < len(old) || p < len(putItems); {
+		if p < len(putItems) && (i == len(old) || !t.less(old[i].i, putItems[p])) {
+			v := putItems[p]
+			p++
+			if t.intern != nil {
+				v = t.intern(v)
+			}
+			if i < len(old) && !t.less(v, old[i].i) {
+				n := old[i]
+				i++
+				n.i = v
+				nodes = append(nodes, n)
+			} else {
+				nodes = append(nodes, t.newNode(v))
+			}
+			if t.hitters != nil {
+				t.hitters.accessed(v)
+			}
+			t.flush(v, false)
+			continue
+		}
+		n := old[i]
+		i++
+		for d < len(delItems) && t.less(delItems[d], n.i) {
+			d++
+		}
+		if d == len(delItems) || t.less(n.i, delItems[d]) {
+			nodes = append(nodes, n)
+			continue
+		}
+		deleted := n.i
+		t.putNode(n)
+		ops++
+		if t.recycle != nil {
+			t.recycle.add(t, deleted, now)
+		}
+		t.flush(deleted, true)
+	}
+	t.version++
+	if t.root = relink(nodes); t.root != nil {
+		t.root.p = nil
+	}
+	if t.logger != nil {
+		for _, v := range putItems {
+			t.checkInsert(v)
+		}
+	}
+	if t.alarms != nil {
+		for ; ops > 0; ops-- {
+			t.checkAlarms()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package btree
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+type pressureHandler struct {
+	name string
+	fn   func()
+}
+
+// PressureWatcher frees memory when the heap grows too large by calling
+// handlers registered with OnPressure, such as Tree.Shed, Overlay.Compact,
+// or a function that evicts cold items to a backing store.  It does not run
+// on its own: call Check every so often, usually as a Maintainer task, or
+// call Relieve directly when some other signal says memory is short.
+// Handlers are called with the same locking as whatever calls Check or Relieve,
+// so when Check is a Maintainer task they may use the Trees it guards.
+//
+// Example:
+//
+//	w := NewPressureWatcher(512 << 20)
+//	w.OnPressure("recycle bin", func() { tree.Shed() })
+//	m.Add("memory", w.Check)
+type PressureWatcher struct {
+	// Limit is the heap size in bytes above which Check calls the handlers.
+	Limit uint64
+	// HeapSize returns the current heap size in bytes.  If it is nil,
+	// HeapAlloc from runtime.ReadMemStats is used.
+	HeapSize func() uint64
+	mu       sync.Mutex
+	handlers []pressureHandler
+	reliefs  uint64
+}
+
+// NewPressureWatcher makes a PressureWatcher that calls its handlers
+// when Check finds the heap is bigger than limit bytes.
+func NewPressureWatcher(limit uint64) *PressureWatcher {
+	return &PressureWatcher{Limit: limit}
+}
+
+// OnPressure adds a handler that will be called when memory is short.
+// Handlers are called in the order they were added, so the cheapest
+// ways of freeing memory should be added first.
+func (w *PressureWatcher) OnPressure(name string, fn func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, pressureHandler{name: name, fn: fn})
+}
+
+func (w *PressureWatcher) heapSize() uint64 {
+	if w.HeapSize != nil {
+		return w.HeapSize()
+	}
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// Check calls Relieve if the heap is bigger than Limit.  It always returns
+// nil, so that it can be passed straight to Maintainer.Add.
+func (w *PressureWatcher) Check() error {
+	if w.heapSize() > w.Limit {
+		w.Relieve()
+	}
+	return nil
+}
+
+// Relieve calls every handler, then asks the runtime to return
+// as much freed memory to the operating system as it can.
+func (w *PressureWatcher) Relieve() {
+	w.mu.Lock()
+	handlers := w.handlers
+	w.reliefs++
+	w.mu.Unlock()
+	for _, h := range handlers {
+		h.fn()
+	}
+	debug.FreeOSMemory()
+}
+
+// Reliefs returns how many times Relieve has been called.
+func (w *PressureWatcher) Reliefs() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reliefs
+}
+
+// Shed frees memory held by the Tree's optional features without changing
+// the items in it.  It empties the recycle bin, and forgets the access counts
+// gathered by TrackAccess, which carries on counting from scratch.
+// It returns the number of items thrown out of the recycle bin.
+func (t *Tree[T]) Shed() (dropped int) {
+	if t.recycle != nil {
+		for _, gen := range t.recycle.gens {
+			dropped += gen.tree.count
+		}
+		t.recycle.release()
+	}
+	if t.access != nil {
+		t.access.hits = map[*node[T]]uint64{}
+	}
+	return
+}
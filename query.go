@@ -0,0 +1,145 @@
+package btree
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// ResultSet describes the items in a Tree between two bounds that pass a
+// list of filters.  Nothing is done until a ResultSet is asked for its items,
+// so building up a query with Filter does not make intermediate copies of them.
+// The items are visited in the order of the Tree unless OrderBy is used.
+// A ResultSet reflects the Tree at the time its items are asked for, not when
+// it was made, except for ResultSets that OrderBy had to sort.
+type ResultSet[T any] struct {
+	t           *Tree[T]
+	start, stop Test[T]
+	filters     []Test[T]
+	// sorted is set when items holds the results in their final order.
+	sorted bool
+	items  []T
+}
+
+// Query makes a ResultSet for the items in t between start and stop, which
+// have the same meaning as for Range.
+func (t *Tree[T]) Query(start, stop Test[T]) *ResultSet[T] {
+	return &ResultSet[T]{t: t, start: start, stop: stop}
+}
+
+// Filter returns a new ResultSet holding the items in r that pred returns true for.
+func (r *ResultSet[T]) Filter(pred Test[T]) *ResultSet[T] {
+	res := *r
+	res.filters = append(r.filters[:len(r.filters):len(r.filters)], pred)
+	return &res
+}
+
+func (r *ResultSet[T]) match(v T) bool {
+	for _, f := range r.filters {
+		if !f(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Each calls fn for each item in r in order, stopping early if fn returns false.
+func (r *ResultSet[T]) Each(fn Test[T]) {
+	if r.sorted {
+		for _, v := range r.items {
+			if r.match(v) && !fn(v) {
+				return
+			}
+		}
+		return
+	}
+	scan(r.t.view(), r.start, r.stop, func(v T) bool {
+		return !r.match(v) || fn(v)
+	})
+}
+
+// Count returns the number of items in r.  If r has no filters, Count
+// takes O(log n) time, otherwise it has to visit every item in r.
+func (r *ResultSet[T]) Count() (res int) {
+	switch {
+	case len(r.filters) > 0:
+	case r.sorted:
+		return len(r.items)
+	default:
+		return r.t.countIn(r.start, r.stop)
+	}
+	r.Each(func(T) bool {
+		res++
+		return true
+	})
+	return
+}
+
+// First returns the first item in r and true, or a zero T and false if r is empty.
+func (r *ResultSet[T]) First() (item T, found bool) {
+	if r.sorted {
+		r.Each(func(v T) bool {
+			item, found = v, true
+			return false
+		})
+		return
+	}
+	return r.t.FindFirst(r.start, r.stop, r.match)
+}
+
+// Last returns the last item in r and true, or a zero T and false if r is empty.
+func (r *ResultSet[T]) Last() (item T, found bool) {
+	if r.sorted {
+		for i := len(r.items) - 1; i >= 0; i-- {
+			if r.match(r.items[i]) {
+				return r.items[i], true
+			}
+		}
+		return
+	}
+	return r.t.FindLast(r.start, r.stop, r.match)
+}
+
+// ToSlice returns the items in r in a new slice.
+func (r *ResultSet[T]) ToSlice() (res []T) {
+	r.Each(func(v T) bool {
+		res = append(res, v)
+		return true
+	})
+	return
+}
+
+// OrderBy returns a new ResultSet holding the items in r ordered by lt, with
+// items that lt considers equal kept in their current order.  If index is
+// not nil, it must be a Tree ordered by lt that holds the same items as the
+// Tree r came from.  OrderBy decides whether it is cheaper to sort the items
+// in r or to walk index and pick them out, and only sorts them if it must.
+// A sorted ResultSet holds a copy of its items, and does not see later
+// changes to the Tree.
+func (r *ResultSet[T]) OrderBy(lt LessThan[T], index *Tree[T]) *ResultSet[T] {
+	if index != nil && !r.sorted {
+		if n := r.boundedCount(); n*bits.Len(uint(n)) > index.count {
+			return r.via(index)
+		}
+	}
+	items := r.ToSlice()
+	sort.SliceStable(items, func(i, j int) bool { return lt(items[i], items[j]) })
+	return &ResultSet[T]{t: r.t, sorted: true, items: items}
+}
+
+// boundedCount returns the number of items between the bounds of r, ignoring its filters.
+func (r *ResultSet[T]) boundedCount() int {
+	return (&ResultSet[T]{t: r.t, start: r.start, stop: r.stop}).Count()
+}
+
+// via returns a ResultSet that picks the items of r out of index.
+func (r *ResultSet[T]) via(index *Tree[T]) *ResultSet[T] {
+	start, stop := r.start, r.stop
+	res := &ResultSet[T]{t: index}
+	if start != nil || stop != nil {
+		res.filters = append(res.filters, func(v T) bool {
+			return (start == nil || !start(v)) && (stop == nil || !stop(v))
+		})
+	}
+	res.filters = append(res.filters, r.filters...)
+	return res
+}
@@ -0,0 +1,114 @@
+package btree
+
+import "time"
+
+// recycleGens is how many generations the recycle bin window is split into.
+const recycleGens = 16
+
+type recycleGen[T any] struct {
+	start time.Time
+	tree  *Tree[T]
+}
+
+// recycleBin holds soft deleted items in generations by the time they were
+// deleted, so that whole generations can be purged at once.
+type recycleBin[T any] struct {
+	window, width time.Duration
+	gens          []recycleGen[T]
+}
+
+// SoftDelete makes Delete move items into a recycle bin instead of throwing
+// them away, where Restore can find them until they are purged.  Items stay
+// in the recycle bin for at least window and at most window plus a sixteenth of it.
+// Purging happens during Delete and when Purge is called, which makes Purge
+// a good task for a Maintainer.  Calling SoftDelete with window <= 0 turns
+// soft deletion off and throws away everything in the recycle bin.
+func (t *Tree[T]) SoftDelete(window time.Duration) {
+	if t.recycle != nil {
+		t.recycle.release()
+		t.recycle = nil
+	}
+	if window > 0 {
+		width := window / recycleGens
+		if width == 0 {
+			width = 1
+		}
+		t.recycle = &recycleBin[T]{window: window, width: width}
+	}
+}
+
+// Recycled returns the number of items in the recycle bin.
+func (t *Tree[T]) Recycled() (res int) {
+	if t.recycle != nil {
+		for _, gen := range t.recycle.gens {
+			res += gen.tree.count
+		}
+	}
+	return
+}
+
+// Restore moves the most recently deleted item matching cmp out of the recycle
+// bin and back into the Tree.  It returns ErrNotFound if there is no such item
+// in the recycle bin, and ErrExists if the Tree already holds an equal item.
+// The item goes back in through TryInsert, so it gets the same checks as any
+// other insert, and any error TryInsert returns is returned as well.
+// Whenever an error is returned, the deleted item stays in the recycle bin.
+func (t *Tree[T]) Restore(cmp CompareAgainst[T]) (item T, err error) {
+	if t.recycle == nil {
+		return item, ErrNotFound
+	}
+	gens := t.recycle.gens
+	for i := len(gens) - 1; i >= 0; i-- {
+		found := false
+		if item, found = gens[i].tree.Get(cmp); !found {
+			continue
+		}
+		if _, dup := t.find(item); dup {
+			return item, ErrExists
+		}
+		if err = t.TryInsert(item); err != nil {
+			return item, err
+		}
+		gens[i].tree.Delete(item)
+		return item, nil
+	}
+	return item, ErrNotFound
+}
+
+// Purge throws away the items in the recycle bin that were deleted long
+// enough ago, and returns how many there were.
+func (t *Tree[T]) Purge() int {
+	if t.recycle == nil {
+		return 0
+	}
+	return t.recycle.purge(t.now())
+}
+
+func (r *recycleBin[T]) add(t *Tree[T], item T, now time.Time) {
+	r.purge(now)
+	for _, gen := range r.gens {
+		gen.tree.Delete(item)
+	}
+	if len(r.gens) == 0 || !now.Before(r.gens[len(r.gens)-1].start.Add(r.width)) {
+		gen := recycleGen[T]{start: now, tree: t.Copy()}
+		gen.tree.intern = nil
+		r.gens = append(r.gens, gen)
+	}
+	r.gens[len(r.gens)-1].tree.insertItem(item)
+}
+
+func (r *recycleBin[T]) purge(now time.Time) (dropped int) {
+	for len(r.gens) > 0 && !now.Before(r.gens[0].start.Add(r.width+r.window)) {
+		dropped += r.gens[0].tree.count
+		r.gens[0].tree.Release()
+		r.gens = r.gens[1:]
+	}
+	return
+}
+
+func (r *recycleBin[T]) release() {
+	for _, gen := range r.gens {
+		gen.tree.Release()
+	}
+	r.gens = nil
+}
@@ -0,0 +1,102 @@
+package btree
+
+// SessionView is a private, modifiable view of a shared Tree.  Changes made
+// through the SessionView are kept in a small delta on the side, and reads
+// merge the delta with the shared Tree, so a session sees its own writes
+// without anyone else seeing them until Commit is called.  It is an Overlay
+// with a single layer over the shared Tree.
+// The shared Tree must not be modified while it has SessionViews open,
+// other than by Commit.  Like Tree, SessionView is not safe for concurrent use.
+type SessionView[T any] struct {
+	o *Overlay[T]
+}
+
+// Session opens a new SessionView over t.
+func (t *Tree[T]) Session() *SessionView[T] {
+	o := NewOverlay(t)
+	o.Push()
+	return &SessionView[T]{o: o}
+}
+
+// Base returns the Tree the SessionView is over.
+func (s *SessionView[T]) Base() *Tree[T] { return s.o.Base() }
+
+// Changes returns the number of inserts and deletes the SessionView is holding.
+func (s *SessionView[T]) Changes() int {
+	top := s.o.top()
+	return top.puts.count + top.dels.count
+}
+
+// Len returns the number of items visible through the SessionView.
+// It takes time proportional to the number of inserts the session is holding.
+func (s *SessionView[T]) Len() int {
+	base, top := s.o.Base(), s.o.top()
+	res := base.count - top.dels.count
+	scan(top.puts.view(), nil, nil, func(v T) bool {
+		if _, found := base.find(v); !found {
+			res++
+		}
+		return true
+	})
+	return res
+}
+
+// Get returns the item matching cmp as seen through the SessionView.
+func (s *SessionView[T]) Get(cmp CompareAgainst[T]) (item T, found bool) {
+	return s.o.Get(cmp)
+}
+
+// Has returns true if an item matching cmp is visible through the SessionView.
+func (s *SessionView[T]) Has(cmp CompareAgainst[T]) bool {
+	return s.o.Has(cmp)
+}
+
+// Insert adds or replaces item in the SessionView.
+func (s *SessionView[T]) Insert(item T) {
+	s.o.Insert(item)
+}
+
+// Delete removes item from the SessionView, returning the item that was
+// visible and true, or a zero T and false if there was no such item.
+func (s *SessionView[T]) Delete(item T) (deleted T, found bool) {
+	return s.o.Delete(item)
+}
+
+// Range iterates in ascending order over the items visible through the
+// SessionView that are between start and stop, as with Tree.Range.
+func (s *SessionView[T]) Range(start, stop, iterator Test[T]) {
+	s.o.Range(start, stop, iterator)
+}
+
+// Walk calls iterator for every item visible through the SessionView in ascending order.
+func (s *SessionView[T]) Walk(iterator Test[T]) {
+	s.o.Walk(iterator)
+}
+
+// Commit applies the changes held by the SessionView to its Tree using
+// Delete and Insert, and leaves the SessionView empty and ready for reuse.
+// Other SessionViews over the same Tree see the changes afterwards.
+func (s *SessionView[T]) Commit() {
+	base, top := s.o.Base(), s.o.top()
+	scan(top.dels.view(), nil, nil, func(v T) bool {
+		base.Delete(v)
+		return true
+	})
+	scan(top.puts.view(), nil, nil, func(v T) bool {
+		base.Insert(v)
+		return true
+	})
+	s.Discard()
+}
+
+// Discard throws away the changes held by the SessionView.
+func (s *SessionView[T]) Discard() {
+	s.o.Pop()
+	s.o.Push()
+}
+
+// Release throws away the changes held by the SessionView.
+// The SessionView must not be used afterwards.
+func (s *SessionView[T]) Release() {
+	s.o.Release()
+}
@@ -0,0 +1,166 @@
+package btree
+
+// smallMax is the most items a Tree keeps in a sorted slice instead of nodes.
+// A node costs three pointers, a height and a size on top of its item,
+// which is most of the memory a Tree holding a handful of small items uses.
+// The slice is moved into nodes the first time an insert would take it past
+// smallMax, or when an operation that needs nodes changes the Tree.
+// A Tree that is emptied goes back to using a slice for its next items.
+const smallMax = 16
+
+// compare returns how a is ordered relative to b, the same way the
+// functions passed to NewCmp do.
+func (t *Tree[T]) compare(a, b T) int {
+	switch {
+	case t.cmp != nil:
+		return t.cmp(a, b)
+	case t.less(a, b):
+		return -1
+	case t.less(b, a):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// searchSmall returns the index of the item in t.small equal to v and true,
+// or the index v would be inserted at and false.
+func (t *Tree[T]) searchSmall(v T) (int, bool) {
+	lo, hi := 0, len(t.small)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		switch c := t.compare(t.small[mid], v); {
+		case c < 0:
+			lo = mid + 1
+		case c > 0:
+			hi = mid
+		default:
+			return mid, true
+		}
+	}
+	return lo, false
+}
+
+// getSmall is searchSmall for a CompareAgainst.
+func (t *Tree[T]) getSmall(cmp CompareAgainst[T]) (int, bool) {
+	lo, hi := 0, len(t.small)
+	for lo < hi {
+		mid := int(uint(lo+hi) >> 1)
+		switch cmp(t.small[mid]) {
+		case Less:
+			lo = mid + 1
+		case Greater:
+			hi = mid
+		case Equal:
+			return mid, true
+		default:
+			panic(unorderable)
+		}
+	}
+	return lo, false
+}
+
+// insertSmall inserts or replaces v in t.small, keeping the same counts
+// newNode does.  It returns false without changing anything if t.small is full.
+func (t *Tree[T]) insertSmall(v T) bool {
+	i, found := t.searchSmall(v)
+	if found {
+		if t.quota != nil {
+			t.quota.bytes += t.quota.size(v) - t.quota.size(t.small[i])
+		}
+		t.small[i] = v
+		t.version++
+		return true
+	}
+	if len(t.small) == smallMax {
+		return false
+	}
+	var ref T
+	t.small = append(t.small, ref)
+	copy(t.small[i+1:], t.small[i:])
+	t.small[i] = v
+	if t.quota != nil {
+		t.quota.items++
+		t.quota.bytes += t.quota.size(v)
+	}
+	t.count++
+	t.version++
+	t.insertCount++
+	return true
+}
+
+// removeSmall is remove for a Tree that keeps its items in t.small.
+func (t *Tree[T]) removeSmall(v T) (deleted T, found bool) {
+	i, found := t.searchSmall(v)
+	if !found {
+		return
+	}
+	deleted = t.small[i]
+	last := len(t.small) - 1
+	copy(t.small[i:], t.small[i+1:])
+	var ref T
+	t.small[last] = ref
+	t.small = t.small[:last]
+	if t.quota != nil {
+		t.quota.items--
+		t.quota.bytes -= t.quota.size(deleted)
+	}
+	t.count--
+	t.version++
+	t.removeCount++
+	return
+}
+
+// scanSmall calls iterator on the items in t.small between start and stop,
+// in ascending order, until it returns false.
+func (t *Tree[T]) scanSmall(start, stop, iterator Test[T]) {
+	for i := 0; i < len(t.small); i++ {
+		v := t.small[i]
+		if start != nil && start(v) {
+			continue
+		}
+		if stop != nil && stop(v) || !iterator(v) {
+			return
+		}
+	}
+}
+
+// find returns the item in t equal to v and true, or a zero T and false.
+func (t *Tree[T]) find(v T) (item T, found bool) {
+	if t.small != nil {
+		var i int
+		if i, found = t.searchSmall(v); found {
+			item = t.small[i]
+		}
+	} else if n, dir := t.getExact(t.root, v); n != nil && dir == Equal {
+		item, found = n.i, true
+	}
+	return
+}
+
+// grow moves the items in t.small into nodes, and returns the root of t.
+// Anything that changes the nodes of t must call it first.
+func (t *Tree[T]) grow() *node[T] {
+	if t.small != nil {
+		nodes := make([]*node[T], len(t.small))
+		for i, v := range t.small {
+			nodes[i] = t.nodePool.Get().(*node[T])
+			nodes[i].i = v
+		}
+		t.root, t.small = relink(nodes), nil
+	}
+	return t.root
+}
+
+// view returns the root of t, or the root of a throwaway copy of t.small
+// in nodes, for reading t without changing it.
+func (t *Tree[T]) view() *node[T] {
+	if t.small == nil {
+		return t.root
+	}
+	nodes := make([]*node[T], len(t.small))
+	for i, v := range t.small {
+		nodes[i] = &node[T]{i: v}
+	}
+	return relink(nodes)
+}
@@ -0,0 +1,116 @@
+package btree
+
+// Point is a location on a two-dimensional grid.
+type Point struct {
+	X, Y uint32
+}
+
+// ZOrder returns the position of p along the Z-order (Morton) curve,
+// formed by interleaving the bits of X and Y.  Points that are close to
+// each other on the grid tend to be close to each other along the curve.
+func (p Point) ZOrder() uint64 {
+	return spread(p.X) | spread(p.Y)<<1
+}
+
+// spread moves the bits of v into the even bits of the result.
+func spread(v uint32) uint64 {
+	r := uint64(v)
+	r = (r | r<<16) & 0x0000ffff0000ffff
+	r = (r | r<<8) & 0x00ff00ff00ff00ff
+	r = (r | r<<4) & 0x0f0f0f0f0f0f0f0f
+	r = (r | r<<2) & 0x3333333333333333
+	r = (r | r<<1) & 0x5555555555555555
+	return r
+}
+
+// bigMin returns the smallest Z-order value greater than z that lies within the rectangle
+// whose corners have the Z-order values zmin and zmax.  This is the BIGMIN
+// calculation from Tropf and Herzog's "Multidimensional Range Search in Dynamically Balanced Trees".
+func bigMin(z, zmin, zmax uint64) uint64 {
+	var res uint64
+	for bit := 63; bit >= 0; bit-- {
+		mask := uint64(1) << bit
+		dim := uint64(0x5555555555555555)
+		if bit&1 == 1 {
+			dim <<= 1
+		}
+		lower := dim & (mask - 1)
+		switch {
+		case z&mask == 0 && zmin&mask == 0 && zmax&mask != 0:
+			res = zmin&^lower | mask
+			zmax = zmax&^mask | lower
+		case z&mask == 0 && zmin&mask != 0:
+			return zmin
+		case z&mask != 0 && zmax&mask == 0:
+			return res
+		case z&mask != 0 && zmin&mask == 0:
+			zmin = zmin&^lower | mask
+		}
+	}
+	return res
+}
+
+// Spatial is a lightweight index of items located at Points on a 2D grid.
+// Items are kept in a Tree in Z-order, and rectangle queries are broken
+// up into runs along the Z-order curve.
+type Spatial[T any] struct {
+	tree *Tree[T]
+	loc  func(T) Point
+}
+
+// NewSpatial makes a new Spatial index that finds the location of each item with loc.
+// Items at the same location are ordered by lt, or replace each other if lt is nil.
+func NewSpatial[T any](loc func(T) Point, lt LessThan[T]) *Spatial[T] {
+	return &Spatial[T]{
+		tree: New[T](func(a, b T) bool {
+			za, zb := loc(a).ZOrder(), loc(b).ZOrder()
+			return za < zb || (za == zb && lt != nil && lt(a, b))
+		}),
+		loc: loc,
+	}
+}
+
+// Tree returns the Tree that holds the items, for use with the rest of the Tree API.
+// It must not be reordered.
+func (s *Spatial[T]) Tree() *Tree[T] { return s.tree }
+
+// Len returns the number of items in the index.
+func (s *Spatial[T]) Len() int { return s.tree.Len() }
+
+// Insert adds item to the index, replacing any equal item.
+func (s *Spatial[T]) Insert(item T) { s.tree.Insert(item) }
+
+// Delete removes item from the index, returning the deleted item and true,
+// or a zero T and false if it was not present.
+func (s *Spatial[T]) Delete(item T) (T, bool) { return s.tree.Delete(item) }
+
+// Within calls fn for every item located within the rectangle with corners lo and hi
+// (inclusive) in Z-order, stopping early if fn returns false.
+func (s *Spatial[T]) Within(lo, hi Point, fn Test[T]) {
+	if lo.X > hi.X {
+		lo.X, hi.X = hi.X, lo.X
+	}
+	if lo.Y > hi.Y {
+		lo.Y, hi.Y = hi.Y, lo.Y
+	}
+	zmin, zmax := lo.ZOrder(), hi.ZOrder()
+	from := func(z uint64) Test[T] {
+		return func(item T) bool { return s.loc(item).ZOrder() < z }
+	}
+	stop := func(item T) bool { return s.loc(item).ZOrder() > zmax }
+	iter := s.tree.Iterator(from(zmin), stop)
+	for iter.Next() {
+		item := iter.Item()
+		if p := s.loc(item); p.X >= lo.X && p.X <= hi.X && p.Y >= lo.Y && p.Y <= hi.Y {
+			if !fn(item) {
+				iter.Release()
+			}
+			continue
+		}
+		iter.Release()
+		iter = s.tree.Iterator(from(bigMin(s.loc(item).ZOrder(), zmin, zmax)), stop)
+	}
+}
+
+// Release releases the memory the index holds.
+func (s *Spatial[T]) Release() { s.tree.Release() }
@@ -0,0 +1,161 @@
+package btree
+
+func height[T any](n *node[T]) uint8 {
+	if n == nil {
+		return 0
+	}
+	return n.h
+}
+
+// link makes k the root of a detached subtree with l and r as its children.
+func link[T any](l, k, r *node[T]) *node[T] {
+	k.p, k.l, k.r = nil, l, r
+	if l != nil {
+		l.p = k
+	}
+	if r != nil {
+		r.p = k
+	}
+	k.setHeight()
+	return k
+}
+
+// rotL and rotR are rotateLeft and rotateRight for the roots of detached subtrees.
+func rotL[T any](a *node[T]) *node[T] {
+	b := a.rotateLeft()
+	a.setHeight()
+	b.setHeight()
+	return b
+}
+
+func rotR[T any](a *node[T]) *node[T] {
+	b := a.rotateRight()
+	a.setHeight()
+	b.setHeight()
+	return b
+}
+
+// join makes a balanced subtree out of l, k, and r, where everything in l
+// is less than k and everything in r is greater.  It takes time proportional
+// to the difference in the heights of l and r.
+func join[T any](l, k, r *node[T]) *node[T] {
+	switch hl, hr := height(l), height(r); {
+	case hl > hr+1:
+		return joinRight(l, k, r)
+	case hr > hl+1:
+		return joinLeft(l, k, r)
+	default:
+		return link(l, k, r)
+	}
+}
+
+// joinRight is join for when l is the taller subtree.  It walks down the
+// right spine of l until it finds a subtree that r is nearly as tall as.
+func joinRight[T any](l, k, r *node[T]) *node[T] {
+	ll, c := l.l, l.r
+	if height(c) <= height(r)+1 {
+		mid := link(c, k, r)
+		if height(mid) <= height(ll)+1 {
+			return link(ll, l, mid)
+		}
+		return rotL(link(ll, l, rotR(mid)))
+	}
+	mid := joinRight(c, k, r)
+	res := link(ll, l, mid)
+	if height(mid) <= height(ll)+1 {
+		return res
+	}
+	return rotL(res)
+}
+
+// joinLeft is the mirror image of joinRight.
+func joinLeft[T any](l, k, r *node[T]) *node[T] {
+	c, rr := r.l, r.r
+	if height(c) <= height(l)+1 {
+		mid := link(l, k, c)
+		if height(mid) <= height(rr)+1 {
+			return link(mid, r, rr)
+		}
+		return rotR(link(rotL(mid), r, rr))
+	}
+	mid := joinLeft(l, k, c)
+	res := link(mid, r, rr)
+	if height(mid) <= height(rr)+1 {
+		return res
+	}
+	return rotR(res)
+}
+
+// split divides the subtree rooted at n into the items before returns true
+// for and the rest.  before must return true for a prefix of the items,
+// as the start Test of a Range does.  The roots it returns may have stale
+// parent pointers.
+func split[T any](n *node[T], before Test[T]) (left, right *node[T]) {
+	if n == nil {
+		return nil, nil
+	}
+	l, r := n.l, n.r
+	if before(n.i) {
+		rl, rr := split(r, before)
+		return join(l, n, rl), rr
+	}
+	ll, lr := split(l, before)
+	return ll, join(lr, n, r)
+}
+
+// join2 is join without a middle node.  It takes the smallest node of r
+// to use as one.
+func join2[T any](l, r *node[T]) *node[T] {
+	if r == nil {
+		return l
+	}
+	r, k := splitMin(r)
+	return join(l, k, r)
+}
+
+// splitMin detaches the smallest node from the subtree rooted at n, and
+// returns the rest of the subtree along with it.
+func splitMin[T any](n *node[T]) (rest, least *node[T]) {
+	if n.l == nil {
+		return n.r, n
+	}
+	rest, least = splitMin(n.l)
+	return join(rest, n, n.r), least
+}
+
+// Split moves the items in t that are less than the item cmp compares against
+// into left, and the rest into right, leaving t empty.  left and right have
+// the same ordering and node pool as t, but none of its other settings.
+// Split relinks O(log n) nodes instead of copying items, so it is a cheap way
+// to divide a Tree into ranges.  If rebalancing is deferred, t is rebalanced first.
+// If t is under a Quota, the items are taken off it, which takes O(n) time.
+func (t *Tree[T]) Split(cmp CompareAgainst[T]) (left, right *Tree[T]) {
+	if t.deferred {
+		t.Rebalance()
+	}
+	left, right = t.Copy(), t.Copy()
+	if t.grow() == nil {
+		return
+	}
+	if t.quota != nil {
+		t.quota.items -= t.count
+		t.Walk(func(item T) bool {
+			t.quota.bytes -= t.quota.size(item)
+			return true
+		})
+	}
+	if t.access != nil {
+		t.access.hits = map[*node[T]]uint64{}
+	}
+	left.root, right.root = split(t.root, func(v T) bool { return cmp(v) < 0 })
+	for _, res := range []*Tree[T]{left, right} {
+		if res.root != nil {
+			res.root.p = nil
+			res.count = res.root.size()
+			res.insertCount = uint64(res.count)
+		}
+	}
+	t.root, t.count = nil, 0
+	t.version++
+	return
+}
@@ -1,12 +1,15 @@
 package btree
 
 import (
+	"fmt"
 	"math"
+	"math/bits"
+	"time"
 )
 
 // GetHeight returns an item in the tree with key @key, and it's height in the tree
 func (t *Tree[T]) GetHeight(key CompareAgainst[T]) (result T, depth int) {
-	return t.getHeight(t.root, key)
+	return t.getHeight(t.view(), key)
 }
 
 func (t *Tree[T]) RebalanceStats() (inserts, deletes uint64, balancePerInsert, balancePerDelete float64) {
@@ -36,7 +39,7 @@ func (t *Tree[T]) getHeight(h *node[T], item CompareAgainst[T]) (T, int) {
 // of elements in the tree
 func (t *Tree[T]) HeightStats() (avg, stddev float64) {
 	av := &AvgVar{}
-	heightStats(t.root, 0, av)
+	heightStats(t.view(), 0, av)
 	return av.GetAvg(), av.GetStdDev()
 }
 
@@ -53,6 +56,204 @@ func heightStats[T any](h *node[T], d int, av *AvgVar) {
 	}
 }
 
+// Histogram counts durations in buckets whose bounds are powers of two nanoseconds.
+type Histogram struct {
+	// Buckets[0] counts durations of 0, and Buckets[i] counts durations
+	// of at least 2^(i-1) and less than 2^i nanoseconds.
+	Buckets [64]uint64
+	Count   uint64
+	Total   time.Duration
+}
+
+func (h *Histogram) record(start time.Time) {
+	d := time.Since(start)
+	if d < 0 {
+		d = 0
+	}
+	h.Buckets[bits.Len64(uint64(d))&63]++
+	h.Count++
+	h.Total += d
+}
+
+// Mean returns the average of all the recorded durations.
+func (h *Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Total / time.Duration(h.Count)
+}
+
+// Quantile returns an upper bound on the q'th quantile of the recorded durations,
+// where q is between 0 and 1.  Quantile(0.99) is the 99th percentile.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	target := uint64(math.Ceil(q * float64(h.Count)))
+	var seen uint64
+	for i, c := range h.Buckets {
+		if seen += c; c > 0 && seen >= target {
+			return time.Duration(uint64(1)<<i - 1)
+		}
+	}
+	return 0
+}
+
+type latencyStats struct {
+	insert, delete, get, iterate Histogram
+}
+
+// Stats holds statistics about a Tree.  The latency histograms will
+// be empty unless TrackLatency has been called.
+type Stats struct {
+	Items                              int
+	Inserts, Deletes                   uint64
+	InsertRebalances, DeleteRebalances uint64
+	// Get covers Get, Has and Fetch. Iterate covers finding the
+	// first item when an Iterator starts.
+	Insert, Delete, Get, Iterate Histogram
+}
+
+// TrackLatency turns recording how long Insert, Delete, Get, and starting
+// iteration take on or off.  Turning it off discards what has been recorded.
+func (t *Tree[T]) TrackLatency(enable bool) {
+	switch {
+	case !enable:
+		t.latency = nil
+	case t.latency == nil:
+		t.latency = &latencyStats{}
+	}
+}
+
+// Stats returns the current statistics for the Tree.
+func (t *Tree[T]) Stats() (res Stats) {
+	res.Items = t.count
+	res.Inserts, res.Deletes = t.insertCount, t.removeCount
+	res.InsertRebalances, res.DeleteRebalances = t.insertRebalanceCount, t.removeRebalanceCount
+	if t.latency != nil {
+		res.Insert, res.Delete = t.latency.insert, t.latency.delete
+		res.Get, res.Iterate = t.latency.get, t.latency.iterate
+	}
+	return
+}
+
+// Validate checks that the Tree is internally consistent: that every node
+// has the right parent and height, that the Tree is AVL balanced (unless
+// rebalancing is deferred), that the items are in order, and that Len is correct.
+// It returns an error wrapping ErrCorrupt describing the first problem it finds.
+// Validate visits every item in the Tree.
+func (t *Tree[T]) Validate() error {
+	root := t.view()
+	if root != nil && root.p != nil {
+		return fmt.Errorf("%w: root has a parent", ErrCorrupt)
+	}
+	count := 0
+	var prev *node[T]
+	var check func(n *node[T]) error
+	check = func(n *node[T]) error {
+		if n == nil {
+			return nil
+		}
+		var lh, rh uint8
+		if n.l != nil {
+			if n.l.p != n {
+				return fmt.Errorf("%w: left child has the wrong parent", ErrCorrupt)
+			}
+			lh = n.l.h
+		}
+		if err := check(n.l); err != nil {
+			return err
+		}
+		if prev != nil && !t.less(prev.i, n.i) {
+			return fmt.Errorf("%w: items out of order", ErrCorrupt)
+		}
+		prev = n
+		count++
+		if n.r != nil {
+			if n.r.p != n {
+				return fmt.Errorf("%w: right child has the wrong parent", ErrCorrupt)
+			}
+			rh = n.r.h
+		}
+		if err := check(n.r); err != nil {
+			return err
+		}
+		if h := 1 + max8(lh, rh); n.h != h {
+			return fmt.Errorf("%w: node has height %d, should be %d", ErrCorrupt, n.h, h)
+		}
+		if s := 1 + n.l.size() + n.r.size(); n.size() != s {
+			return fmt.Errorf("%w: node has size %d, should be %d", ErrCorrupt, n.s, s)
+		}
+		if b := int(rh) - int(lh); !t.deferred && (b > 1 || b < -1) {
+			return fmt.Errorf("%w: node has balance %d", ErrCorrupt, b)
+		}
+		return nil
+	}
+	if err := check(root); err != nil {
+		return err
+	}
+	if count != t.count {
+		return fmt.Errorf("%w: Len is %d, but there are %d items", ErrCorrupt, t.count, count)
+	}
+	return nil
+}
+
+func max8(a, b uint8) uint8 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// HealthReport summarizes the state of a Tree for use by health checks and monitoring.
+// It does not report how many freed nodes the node pool retains.  A sync.Pool
+// cannot say how many items it holds, the garbage collector empties it
+// without notice, and every Tree made by Copy shares the same pool.  The
+// Inserts and Deletes counts in Stats are the closest measure available.
+type HealthReport struct {
+	Stats
+	// Err is the result of Validate.
+	Err error
+	// Height is the height of the Tree, and OptimalHeight is the height of a
+	// perfectly balanced tree with the same number of items.
+	Height, OptimalHeight int
+	// HeightRatio is Height / OptimalHeight, or 1 for an empty Tree.
+	// AVL trees never have a ratio greater than about 1.44.
+	HeightRatio float64
+	// RebalanceRate is the rebalances per second measured by the most
+	// recent alarm check, or 0 if SetAlarms has not been called.
+	RebalanceRate float64
+	// AvgDepth and DepthStdDev are from HeightStats.
+	AvgDepth, DepthStdDev float64
+	// Deferred is true if rebalancing is currently deferred.
+	Deferred bool
+}
+
+// HealthReport validates the Tree and gathers statistics about it into a HealthReport.
+// Like Validate, it visits every item in the Tree.
+func (t *Tree[T]) HealthReport() (res HealthReport) {
+	res.Stats = t.Stats()
+	res.Err = t.Validate()
+	res.Deferred = t.deferred
+	res.HeightRatio = t.HeightRatio()
+	if t.alarms != nil {
+		res.RebalanceRate = t.alarms.rate
+	}
+	if root := t.view(); root != nil {
+		res.Height = int(root.h)
+		res.OptimalHeight = bits.Len(uint(t.count))
+		res.AvgDepth, res.DepthStdDev = t.HeightStats()
+	}
+	return
+}
+
+// HeightRatio returns the height of the Tree divided by the height of a
+// perfectly balanced tree with the same number of items, or 1 for an empty Tree.
+// Unlike HealthReport, it takes constant time.
+func (t *Tree[T]) HeightRatio() float64 {
+	if t.root == nil {
+		return 1
+	}
+	return float64(t.root.h) / float64(bits.Len(uint(t.count)))
+}
+
 // AvgVar maintains the average and variance of a stream of numbers
 // in a space-efficient manner.
 type AvgVar struct {
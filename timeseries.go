@@ -0,0 +1,161 @@
+package btree
+
+import "time"
+
+type tsBucket[T any] struct {
+	start time.Time
+	tree  *Tree[T]
+}
+
+// TimeSeries spreads items over a series of Trees, one per fixed-width
+// span of time, based on a timestamp taken from each item.  Old
+// items can then be dropped a whole bucket at a time with Expire, which is
+// much cheaper than deleting them from one big Tree one by one.
+// Items that are equal according to the TimeSeries' LessThan must have the same timestamp.
+type TimeSeries[T any] struct {
+	less    LessThan[T]
+	at      func(T) time.Time
+	width   time.Duration
+	buckets *Tree[*tsBucket[T]]
+	proto   *Tree[T]
+	clock   Clock
+}
+
+// NewTimeSeries makes a new TimeSeries whose buckets are ordered by lt, and which
+// uses at to decide which width-long bucket each item belongs in.
+func NewTimeSeries[T any](lt LessThan[T], at func(T) time.Time, width time.Duration) *TimeSeries[T] {
+	if width <= 0 {
+		panic("TimeSeries bucket width must be positive")
+	}
+	return &TimeSeries[T]{
+		less:    lt,
+		at:      at,
+		width:   width,
+		buckets: New[*tsBucket[T]](func(a, b *tsBucket[T]) bool { return a.start.Before(b.start) }),
+		proto:   New[T](lt),
+	}
+}
+
+func (ts *TimeSeries[T]) bucketCmp(start time.Time) CompareAgainst[*tsBucket[T]] {
+	return func(b *tsBucket[T]) int {
+		switch {
+		case b.start.Before(start):
+			return Less
+		case b.start.After(start):
+			return Greater
+		default:
+			return Equal
+		}
+	}
+}
+
+// Len returns the number of items in all the buckets.
+func (ts *TimeSeries[T]) Len() (res int) {
+	ts.buckets.Walk(func(b *tsBucket[T]) bool {
+		res += b.tree.Len()
+		return true
+	})
+	return
+}
+
+// Buckets returns the number of buckets.
+func (ts *TimeSeries[T]) Buckets() int { return ts.buckets.Len() }
+
+// Insert adds item to the bucket its timestamp falls in, creating the bucket if needed.
+func (ts *TimeSeries[T]) Insert(item T) {
+	start := ts.at(item).Truncate(ts.width)
+	b, found := ts.buckets.Get(ts.bucketCmp(start))
+	if !found {
+		b = &tsBucket[T]{start: start, tree: ts.proto.Copy()}
+		ts.buckets.Insert(b)
+	}
+	b.tree.Insert(item)
+}
+
+// Delete removes item from its bucket, returning the deleted item and true,
+// or a zero T and false if it was not present.  Buckets left empty are dropped.
+func (ts *TimeSeries[T]) Delete(item T) (deleted T, found bool) {
+	b, ok := ts.buckets.Get(ts.bucketCmp(ts.at(item).Truncate(ts.width)))
+	if !ok {
+		return
+	}
+	if deleted, found = b.tree.Delete(item); found && b.tree.Len() == 0 {
+		ts.buckets.Delete(b)
+		b.tree.Release()
+	}
+	return
+}
+
+// Range calls fn in ascending order for every item whose timestamp is at or after from
+// and before to, stopping early if fn returns false.  Items from the buckets
+// that overlap the time range are merged together as they are iterated over.
+func (ts *TimeSeries[T]) Range(from, to time.Time, fn Test[T]) {
+	var cursors []*cursor[T]
+	ts.buckets.Range(
+		func(b *tsBucket[T]) bool { return !b.start.Add(ts.width).After(from) },
+		func(b *tsBucket[T]) bool { return !b.start.Before(to) },
+		func(b *tsBucket[T]) bool {
+			cursors = append(cursors, b.tree.cursor())
+			return true
+		})
+	defer func() {
+		for _, c := range cursors {
+			c.iter.Release()
+		}
+	}()
+	for {
+		var low *cursor[T]
+		for _, c := range cursors {
+			if c.ok && (low == nil || ts.less(c.item(), low.item())) {
+				low = c
+			}
+		}
+		if low == nil {
+			return
+		}
+		item := low.item()
+		low.next()
+		if when := ts.at(item); !when.Before(from) && when.Before(to) && !fn(item) {
+			return
+		}
+	}
+}
+
+// Expire drops every bucket that only holds items from before cutoff,
+// and returns the number of items that were dropped.
+func (ts *TimeSeries[T]) Expire(cutoff time.Time) (dropped int) {
+	for {
+		b, found := ts.buckets.Min()
+		if !found || b.start.Add(ts.width).After(cutoff) {
+			return
+		}
+		ts.buckets.Delete(b)
+		dropped += b.tree.Len()
+		b.tree.Release()
+	}
+}
+
+// SetClock makes ExpireOlderThan get the time from c.
+// Passing nil goes back to SystemClock.
+func (ts *TimeSeries[T]) SetClock(c Clock) {
+	ts.clock = c
+}
+
+// ExpireOlderThan is Expire with a cutoff of age before the current time,
+// which keeps a sliding window of items in the TimeSeries.
+func (ts *TimeSeries[T]) ExpireOlderThan(age time.Duration) int {
+	clock := ts.clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	return ts.Expire(clock.Now().Add(-age))
+}
+
+// Release releases the memory held by all the buckets.
+func (ts *TimeSeries[T]) Release() {
+	ts.buckets.Walk(func(b *tsBucket[T]) bool {
+		b.tree.Release()
+		return true
+	})
+	ts.buckets.Release()
+}
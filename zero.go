@@ -0,0 +1,97 @@
+package btree
+
+import (
+	"strings"
+	"sync"
+)
+
+// SetLess sets the LessThan the Tree is ordered by, and returns the Tree so
+// it can be chained.  It is meant for Trees that were not made by New, such
+// as a zero Tree embedded in another struct.  SetLess panics if the Tree
+// is not empty, since its items would no longer be in order.
+func (t *Tree[T]) SetLess(lt LessThan[T]) *Tree[T] {
+	if t.count != 0 {
+		panic("SetLess called on a Tree that is not empty")
+	}
+	t.less, t.cmp, t.comparator = lt, nil, ""
+	if t.hitters != nil {
+		t.hitters.reorder(lt)
+	}
+	return t
+}
+
+// init finishes setting up a zero Tree the first time it is used.  If SetLess
+// has not been called, Trees of the builtin integer, float, and string types
+// are put in ascending order, and Trees of other types get ErrNoOrdering.
+// Released Trees get ErrReleased.
+func (t *Tree[T]) init() error {
+	if t.released {
+		return ErrReleased
+	}
+	if t.nodePool != nil {
+		return nil
+	}
+	if t.less == nil {
+		if _, ok := any(t).(*Tree[string]); ok {
+			cmp := any(strings.Compare).(func(T, T) int)
+			t.less = func(a, b T) bool { return cmp(a, b) < 0 }
+			t.cmp = cmp
+		} else if t.less = defaultLess[T](); t.less == nil {
+			return ErrNoOrdering
+		}
+	}
+	t.nodePool = &sync.Pool{New: func() any { return &node[T]{} }}
+	return nil
+}
+
+// mustInit is init for methods that cannot return an error.
+func (t *Tree[T]) mustInit() {
+	switch t.init() {
+	case nil:
+	case ErrReleased:
+		panic("Tree used after Release")
+	default:
+		panic("Tree has no ordering: make it with New or call SetLess before using it")
+	}
+}
+
+func ascending[O ~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr | ~float32 | ~float64](a, b O) bool {
+	return a < b
+}
+
+// defaultLess returns the ascending LessThan for T if T is a builtin
+// integer or float type, or nil otherwise.
+func defaultLess[T any]() LessThan[T] {
+	var lt any
+	switch any(*new(T)).(type) {
+	case int:
+		lt = LessThan[int](ascending[int])
+	case int8:
+		lt = LessThan[int8](ascending[int8])
+	case int16:
+		lt = LessThan[int16](ascending[int16])
+	case int32:
+		lt = LessThan[int32](ascending[int32])
+	case int64:
+		lt = LessThan[int64](ascending[int64])
+	case uint:
+		lt = LessThan[uint](ascending[uint])
+	case uint8:
+		lt = LessThan[uint8](ascending[uint8])
+	case uint16:
+		lt = LessThan[uint16](ascending[uint16])
+	case uint32:
+		lt = LessThan[uint32](ascending[uint32])
+	case uint64:
+		lt = LessThan[uint64](ascending[uint64])
+	case uintptr:
+		lt = LessThan[uintptr](ascending[uintptr])
+	case float32:
+		lt = LessThan[float32](ascending[float32])
+	case float64:
+		lt = LessThan[float64](ascending[float64])
+	default:
+		return nil
+	}
+	return lt.(LessThan[T])
+}